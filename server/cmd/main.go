@@ -4,9 +4,11 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
 	"time"
 	
 	"github.com/YogendrasinghRathod/server/internal/auth"
+	"github.com/YogendrasinghRathod/server/internal/storage"
 	"github.com/YogendrasinghRathod/server/pkg/routes"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
@@ -51,16 +53,36 @@ func main() {
 	}
 
 	// Create auth handler
-	authHandler, err := auth.NewAuthHandler(db)
+	authHandler, err := auth.NewAuthHandler(db, redisClient)
 	if err != nil {
 		log.Fatalf("Failed to create auth handler: %v", err)
 	}
 
+	// Initialize storage backends (STORAGE_TYPE selects where uploads go)
+	storageRegistry, err := storage.NewRegistryFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
 	// Initialize Gin router
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from the proxies in TRUSTED_PROXIES
+	// (comma-separated IPs or CIDRs); with none, the client IP is the
+	// connection's address. API key allowlists, login lockouts and rate
+	// limits all depend on it.
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Setup routes (now with correct parameters)
-	routes.SetupRoutes(router, db, redisClient, authHandler)
+	routes.SetupRoutes(router, db, redisClient, authHandler, storageRegistry)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type adminUser struct {
+	ID              string     `json:"id" db:"id"`
+	Email           string     `json:"email" db:"email"`
+	Role            string     `json:"role" db:"role"`
+	Plan            string     `json:"plan" db:"plan"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	TwoFactor       bool       `json:"two_factor_enabled" db:"two_factor_enabled"`
+	DisabledAt      *time.Time `json:"disabled_at" db:"disabled_at"`
+	DisabledReason  *string    `json:"disabled_reason" db:"disabled_reason"`
+	DisabledBy      *string    `json:"disabled_by" db:"disabled_by"`
+}
+
+const adminUserColumns = `
+	id, email, role, plan, email_verified_at, totp_enabled_at IS NOT NULL AS two_factor_enabled,
+	disabled_at, disabled_reason, disabled_by`
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type DisableUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminResetPasswordRequest struct {
+	// Password is set directly when given; otherwise a reset link is
+	// emailed to the user
+	Password string `json:"password" binding:"omitempty,min=8"`
+}
+
+// targetUser resolves the :user_id route parameter, writing a 404 if it
+// doesn't name a user.
+func (h *AuthHandler) targetUser(c *gin.Context) (*adminUser, bool) {
+	if _, err := uuid.Parse(c.Param("user_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+
+	var user adminUser
+	err := h.db.Get(&user, `SELECT `+adminUserColumns+` FROM users WHERE id = $1`, c.Param("user_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// ListUsers lists accounts, optionally filtered by an email substring (q),
+// role and status (active or disabled).
+func (h *AuthHandler) ListUsers(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return
+	}
+
+	var conditions []string
+	var args []interface{}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		args = append(args, "%"+strings.ToLower(q)+"%")
+		conditions = append(conditions, "lower(email) LIKE $"+strconv.Itoa(len(args)))
+	}
+	if role := c.Query("role"); role != "" {
+		if !ValidRole(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+			return
+		}
+		args = append(args, role)
+		conditions = append(conditions, "role = $"+strconv.Itoa(len(args)))
+	}
+	switch c.Query("status") {
+	case "":
+	case "active":
+		conditions = append(conditions, "disabled_at IS NULL")
+	case "disabled":
+		conditions = append(conditions, "disabled_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or disabled"})
+		return
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := h.db.Get(&total, `SELECT COUNT(*) FROM users`+where, args...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+		return
+	}
+
+	users := []adminUser{}
+	args = append(args, limit, offset)
+	err = h.db.Select(&users, `SELECT `+adminUserColumns+` FROM users`+where+
+		` ORDER BY email LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total, "limit": limit, "offset": offset})
+}
+
+func (h *AuthHandler) GetUser(c *gin.Context) {
+	if user, ok := h.targetUser(c); ok {
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// UpdateUserRole changes a user's role. Admins can't change their own, so
+// there is always at least one admin left.
+func (h *AuthHandler) UpdateUserRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == c.GetString("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change your own role"})
+		return
+	}
+
+	if _, err := h.db.Exec(`UPDATE users SET role = $1 WHERE id = $2`, req.Role, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+		return
+	}
+	user.Role = req.Role
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// DisableUser blocks an account from signing in and ends its sessions; its
+// API keys stop working too. Files and share links are left alone.
+func (h *AuthHandler) DisableUser(c *gin.Context) {
+	var req DisableUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	adminID := c.GetString("userID")
+	if user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot disable your own account"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var reason *string
+	if r := strings.TrimSpace(req.Reason); r != "" {
+		reason = &r
+	}
+	err := h.db.GetContext(ctx, user, `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, NOW()), disabled_reason = $1, disabled_by = $2
+		WHERE id = $3
+		RETURNING `+adminUserColumns, reason, adminID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable user"})
+		return
+	}
+
+	if _, err := h.revokeSessions(ctx, user.ID, "", ""); err != nil {
+		log.Printf("revoke sessions for disabled user %s: %v", user.ID, err)
+	}
+	h.forgetAPIKeys(ctx, user.ID)
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+func (h *AuthHandler) EnableUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Get(user, `
+		UPDATE users
+		SET disabled_at = NULL, disabled_reason = NULL, disabled_by = NULL
+		WHERE id = $1
+		RETURNING `+adminUserColumns, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// AdminResetPassword signs a user out everywhere and either sets the given
+// password or emails them a reset link.
+func (h *AuthHandler) AdminResetPassword(c *gin.Context) {
+	var req AdminResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+			return
+		}
+		if _, err := h.db.ExecContext(ctx, `UPDATE users SET password_hash = $1 WHERE id = $2`, string(hashedPassword), user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+			return
+		}
+	}
+
+	if _, err := h.revokeSessions(ctx, user.ID, "", ""); err != nil {
+		log.Printf("revoke sessions for %s after admin password reset: %v", user.ID, err)
+	}
+
+	if req.Password != "" {
+		go h.sendMail("password_changed", user.Email, gin.H{})
+		c.JSON(http.StatusOK, gin.H{"message": "password reset successfully"})
+		return
+	}
+	go h.sendPasswordReset(user.ID, user.Email)
+	c.JSON(http.StatusAccepted, gin.H{"message": "password reset email sent"})
+}
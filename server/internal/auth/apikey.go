@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// API key scopes. A key may only call routes registered under one of its
+// scopes; everything else needs a login session. Admin routes also check
+// the owner's role.
+const (
+	ScopeUpload = "upload"
+	ScopeRead   = "read"
+	ScopeShare  = "share"
+	ScopeAdmin  = "admin"
+)
+
+var validScopes = map[string]bool{ScopeUpload: true, ScopeRead: true, ScopeShare: true, ScopeAdmin: true}
+
+// apiKeyPrefix marks a bearer token as an API key rather than a JWT.
+const apiKeyPrefix = "fsk_"
+
+const (
+	apiKeyCacheTTL = 5 * time.Minute
+	// lastUsedInterval limits how often last-used tracking writes
+	lastUsedInterval = time.Minute
+)
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required,max=100"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+type apiKey struct {
+	ID         string         `json:"id" db:"id"`
+	UserID     string         `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"key_prefix"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	AllowedIPs pq.StringArray `json:"allowed_ips" db:"allowed_ips"`
+	ExpiresAt  *time.Time     `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP *string        `json:"last_used_ip" db:"last_used_ip"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	RevokedAt  *time.Time     `json:"revoked_at" db:"revoked_at"`
+}
+
+func (k *apiKey) hasScope(scopes []string) bool {
+	for _, want := range scopes {
+		for _, have := range k.Scopes {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsIP checks the client address against the key's allowlist of IPs
+// and CIDR ranges; an empty list allows any address.
+func (k *apiKey) allowsIP(addr string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyCacheKey(hash string) string {
+	return "api_key:" + hash
+}
+
+// lookupAPIKey finds a key by its hash, from Redis when cached.
+func (h *AuthHandler) lookupAPIKey(ctx context.Context, hash string) (*apiKey, error) {
+	var key apiKey
+	if cached, err := h.redisClient.Get(ctx, apiKeyCacheKey(hash)).Bytes(); err == nil {
+		if json.Unmarshal(cached, &key) == nil {
+			return &key, nil
+		}
+	}
+
+	err := h.db.GetContext(ctx, &key, `
+		SELECT k.id, k.user_id, k.name, k.key_prefix, k.scopes, k.allowed_ips, k.expires_at,
+		       k.last_used_at, host(k.last_used_ip) AS last_used_ip, k.created_at, k.revoked_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND u.disabled_at IS NULL`, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(key); err == nil {
+		h.redisClient.Set(ctx, apiKeyCacheKey(hash), data, apiKeyCacheTTL)
+	}
+	return &key, nil
+}
+
+// authenticateAPIKey is AuthMiddleware for a bearer API key. It sets the
+// same userID as a session would, plus apiKeyID.
+func (h *AuthHandler) authenticateAPIKey(c *gin.Context, token string, scopes []string) {
+	ctx := c.Request.Context()
+	hash := hashToken(token)
+	key, err := h.lookupAPIKey(ctx, hash)
+	if err != nil || key.RevokedAt != nil || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired API key"})
+		return
+	}
+	if !key.allowsIP(c.ClientIP()) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key not allowed from this address"})
+		return
+	}
+	if !key.hasScope(scopes) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the scope for this endpoint"})
+		return
+	}
+
+	// Record use at most once a minute per key
+	if ok, _ := h.redisClient.SetNX(ctx, "api_key_used:"+key.ID, "1", lastUsedInterval).Result(); ok {
+		go func(id, ip string) {
+			_, err := h.db.Exec(`UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $1 WHERE id = $2`, ip, id)
+			if err != nil {
+				log.Printf("api key %s: last used: %v", id, err)
+			}
+		}(key.ID, c.ClientIP())
+	}
+
+	c.Set("userID", key.UserID)
+	c.Set("apiKeyID", key.ID)
+	c.Next()
+}
+
+// CreateAPIKey issues a key for the caller. The key itself is only in this
+// response; afterwards it is identified by its prefix.
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope: " + scope})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, entry := range req.AllowedIPs {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IP or CIDR: " + entry})
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	userID := c.GetString("userID")
+	if seen[ScopeAdmin] {
+		var role string
+		if err := h.db.Get(&role, `SELECT role FROM users WHERE id = $1`, userID); err != nil || role == RoleUser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only moderators and admins can create admin keys"})
+			return
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key"})
+		return
+	}
+	token := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	prefix := token[:len(apiKeyPrefix)+8]
+
+	var allowedIPs interface{}
+	if len(req.AllowedIPs) > 0 {
+		allowedIPs = pq.Array(req.AllowedIPs)
+	}
+
+	var key apiKey
+	err := h.db.Get(&key, `
+		INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, allowed_ips, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, user_id, name, key_prefix, scopes, allowed_ips, expires_at,
+		          last_used_at, host(last_used_ip) AS last_used_ip, created_at, revoked_at`,
+		userID, req.Name, prefix, hashToken(token), pq.Array(scopes), allowedIPs, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": key,
+		"key":     token,
+		"message": "store this key now; it won't be shown again",
+	})
+}
+
+// ListAPIKeys lists the caller's keys, including revoked and expired ones.
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	keys := []apiKey{}
+	err := h.db.Select(&keys, `
+		SELECT id, user_id, name, key_prefix, scopes, allowed_ips, expires_at,
+		       last_used_at, host(last_used_ip) AS last_used_ip, created_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RevokeAPIKey permanently disables one of the caller's keys.
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	var hash string
+	err := h.db.Get(&hash, `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id::text = $1 AND user_id = $2 AND revoked_at IS NULL
+		RETURNING key_hash`, c.Param("key_id"), c.GetString("userID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke key"})
+		return
+	}
+
+	h.redisClient.Del(c.Request.Context(), apiKeyCacheKey(hash))
+	c.JSON(http.StatusOK, gin.H{"message": "key revoked successfully"})
+}
+
+// forgetAPIKeys drops a user's keys from the cache, so a change to the
+// account (e.g. disabling it) applies to them at once.
+func (h *AuthHandler) forgetAPIKeys(ctx context.Context, userID string) {
+	var hashes []string
+	if err := h.db.SelectContext(ctx, &hashes, `SELECT key_hash FROM api_keys WHERE user_id = $1`, userID); err != nil {
+		return
+	}
+	for _, hash := range hashes {
+		h.redisClient.Del(ctx, apiKeyCacheKey(hash))
+	}
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
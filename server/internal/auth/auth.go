@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
@@ -10,16 +11,41 @@ import (
 	"time"
 	"strings"
 
+	"github.com/YogendrasinghRathod/server/internal/mailer"
+	"github.com/YogendrasinghRathod/server/internal/signing"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
 	db            *sqlx.DB
-	jwtSecret     []byte
+	redisClient   *redis.Client
+	keys          *signing.KeySet
 	tokenDuration time.Duration
+	// refreshDuration is how long a refresh token, and so an idle session,
+	// lasts
+	refreshDuration time.Duration
+	mailer          mailer.Mailer
+	// appBaseURL prefixes links in emails, e.g. https://app.example.com
+	appBaseURL string
+	// requireVerification blocks login until the email is verified
+	requireVerification bool
+	// oidc is nil unless OIDC login is configured
+	oidc *oidcConfig
+	// requireShare2FA mirrors the file handler's rule that only accounts
+	// with 2FA may own share links
+	requireShare2FA bool
+	// accountLimits and ipLimits throttle failed logins; lockoutDuration
+	// is how long hitting a limit locks the account or IP out
+	accountLimits   loginLimits
+	ipLimits        loginLimits
+	lockoutDuration time.Duration
+	// constantRegistration answers registration the same way whether or
+	// not the email is taken, so it can't be used to find accounts
+	constantRegistration bool
 }
 
 type RegisterRequest struct {
@@ -33,30 +59,129 @@ type LoginRequest struct {
 }
 
 type User struct {
-	ID           string `db:"id"`
-	Email        string `db:"email"`
-	PasswordHash string `db:"password_hash"`
+	ID              string     `db:"id"`
+	Email           string     `db:"email"`
+	PasswordHash    string     `db:"password_hash"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	TOTPEnabledAt   *time.Time `db:"totp_enabled_at"`
+	DisabledAt      *time.Time `db:"disabled_at"`
 }
 
-func NewAuthHandler(db *sqlx.DB) (*AuthHandler, error) {
+func NewAuthHandler(db *sqlx.DB, redisClient *redis.Client) (*AuthHandler, error) {
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if len(jwtSecret) < 32 {
 		return nil, errors.New("JWT_SECRET must be at least 32 characters long")
 	}
 
-	expHours := 24 // Default to 24 hours
-	if expStr := os.Getenv("JWT_EXPIRATION_HOURS"); expStr != "" {
-		var err error
-		expHours, err = strconv.Atoi(expStr)
+	// Access tokens are short-lived now that they can be refreshed;
+	// JWT_EXPIRATION_HOURS is still honoured if it is the only one set
+	tokenDuration := 15 * time.Minute // Default to 15 minutes
+	if expStr := os.Getenv("ACCESS_TOKEN_TTL_MINUTES"); expStr != "" {
+		expMinutes, err := strconv.Atoi(expStr)
+		if err != nil || expMinutes <= 0 {
+			return nil, errors.New("ACCESS_TOKEN_TTL_MINUTES must be a positive integer")
+		}
+		tokenDuration = time.Duration(expMinutes) * time.Minute
+	} else if expStr := os.Getenv("JWT_EXPIRATION_HOURS"); expStr != "" {
+		expHours, err := strconv.Atoi(expStr)
 		if err != nil || expHours <= 0 {
 			return nil, errors.New("JWT_EXPIRATION_HOURS must be a positive integer")
 		}
+		tokenDuration = time.Duration(expHours) * time.Hour
+	}
+
+	refreshHours := 30 * 24 // Default to 30 days
+	if expStr := os.Getenv("REFRESH_TOKEN_TTL_HOURS"); expStr != "" {
+		var err error
+		refreshHours, err = strconv.Atoi(expStr)
+		if err != nil || refreshHours <= 0 {
+			return nil, errors.New("REFRESH_TOKEN_TTL_HOURS must be a positive integer")
+		}
+	}
+
+	// Tokens are signed with rotating asymmetric keys; JWT_SECRET now
+	// only encrypts their private halves at rest
+	algorithm := os.Getenv("JWT_SIGNING_ALG")
+	if algorithm == "" {
+		algorithm = signing.RS256
+	}
+	rotationDays := 30 // Default to 30 days
+	if v := os.Getenv("JWT_KEY_ROTATION_DAYS"); v != "" {
+		var err error
+		rotationDays, err = strconv.Atoi(v)
+		if err != nil || rotationDays <= 0 {
+			return nil, errors.New("JWT_KEY_ROTATION_DAYS must be a positive integer")
+		}
+	}
+	// Old keys verify for a token lifetime plus leeway for clock skew
+	keys, err := signing.New(db, []byte(jwtSecret), algorithm,
+		time.Duration(rotationDays)*24*time.Hour, tokenDuration+5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFailures := 10 // Default to 10 failed logins per account
+	if v := os.Getenv("LOGIN_MAX_FAILURES"); v != "" {
+		var err error
+		maxFailures, err = strconv.Atoi(v)
+		if err != nil || maxFailures <= 0 {
+			return nil, errors.New("LOGIN_MAX_FAILURES must be a positive integer")
+		}
+	}
+	maxIPFailures := 100 // Default to 100 failed logins per IP
+	if v := os.Getenv("LOGIN_IP_MAX_FAILURES"); v != "" {
+		var err error
+		maxIPFailures, err = strconv.Atoi(v)
+		if err != nil || maxIPFailures <= 0 {
+			return nil, errors.New("LOGIN_IP_MAX_FAILURES must be a positive integer")
+		}
+	}
+	lockoutMinutes := 30 // Default to 30 minutes
+	if v := os.Getenv("LOGIN_LOCKOUT_MINUTES"); v != "" {
+		var err error
+		lockoutMinutes, err = strconv.Atoi(v)
+		if err != nil || lockoutMinutes <= 0 {
+			return nil, errors.New("LOGIN_LOCKOUT_MINUTES must be a positive integer")
+		}
+	}
+
+	m, err := mailer.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// Verification is required unless explicitly turned off, and then its
+	// links must be able to reach the user
+	requireVerification := os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+	if _, ok := m.(mailer.Disabled); ok && requireVerification {
+		return nil, errors.New("REQUIRE_EMAIL_VERIFICATION needs SMTP_HOST (or MAILER=log in development)")
+	}
+
+	oidcCfg, err := oidcConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	appBaseURL := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:8080"
 	}
 
 	return &AuthHandler{
-		db:            db,
-		jwtSecret:     []byte(jwtSecret),
-		tokenDuration: time.Duration(expHours) * time.Hour,
+		db:              db,
+		redisClient:     redisClient,
+		keys:            keys,
+		tokenDuration:   tokenDuration,
+		refreshDuration: time.Duration(refreshHours) * time.Hour,
+		mailer:          m,
+		appBaseURL:      appBaseURL,
+		oidc:            oidcCfg,
+		requireVerification: requireVerification,
+		requireShare2FA:     os.Getenv("REQUIRE_2FA_FOR_SHARE_LINKS") == "true",
+		// Delays start after a few failures, well before the lockout
+		accountLimits:        loginLimits{backoffAfter: int64(maxFailures / 3), lockAfter: int64(maxFailures)},
+		ipLimits:             loginLimits{backoffAfter: int64(maxIPFailures / 5), lockAfter: int64(maxIPFailures)},
+		lockoutDuration:      time.Duration(lockoutMinutes) * time.Minute,
+		constantRegistration: os.Getenv("REGISTER_CONSTANT_RESPONSE") == "true",
 	}, nil
 }
 
@@ -67,28 +192,35 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Hash password first so a taken email answers no faster
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
 	// Check if user already exists
 	var count int
-	err := h.db.Get(&count, "SELECT COUNT(*) FROM users WHERE email = $1", req.Email)
+	err = h.db.Get(&count, "SELECT COUNT(*) FROM users WHERE email = $1", req.Email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 		return
 	}
 	if count > 0 {
+		if h.constantRegistration {
+			// Tell the owner instead of the caller
+			go h.sendMail("account_exists", req.Email, gin.H{"Link": h.appBaseURL + "/forgot-password"})
+			registered(c)
+			return
+		}
 		c.JSON(http.StatusConflict, gin.H{"error": "email already exists"})
 		return
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
-		return
-	}
-
 	// Create user
-	_, err = h.db.Exec(
-		"INSERT INTO users (email, password_hash) VALUES ($1, $2)",
+	var userID string
+	err = h.db.Get(&userID,
+		"INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id",
 		req.Email, string(hashedPassword),
 	)
 	if err != nil {
@@ -96,7 +228,20 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "user created successfully"})
+	// Send the verification link
+	go h.sendVerification(userID, req.Email)
+
+	if h.constantRegistration {
+		registered(c)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "user created successfully; check your email to verify your address"})
+}
+
+// registered is the response to every registration when
+// REGISTER_CONSTANT_RESPONSE is on.
+func registered(c *gin.Context) {
+	c.JSON(http.StatusAccepted, gin.H{"message": "check your email to finish creating your account"})
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -106,10 +251,19 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse attempts while the account or IP is backing off or locked
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	if wait, locked := h.loginBlocked(ctx, req.Email, ip); wait > 0 {
+		tooManyAttempts(c, wait, locked)
+		return
+	}
+
 	// Get user from database
 	var user User
-	err := h.db.Get(&user, "SELECT id, email, password_hash FROM users WHERE email = $1", req.Email)
+	err := h.db.Get(&user, "SELECT id, email, password_hash, email_verified_at, totp_enabled_at, disabled_at FROM users WHERE email = $1", req.Email)
 	if err != nil {
+		h.recordLoginFailure(ctx, req.Email, ip, "")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
@@ -117,57 +271,59 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// Compare passwords
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
+		h.recordLoginFailure(ctx, req.Email, ip, user.ID)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	// Generate token
-	tokenString, err := h.GenerateToken(user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+	if user.DisabledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
 		return
 	}
 
-	// Store token in database
-	_, err = h.db.Exec(
-		"INSERT INTO auth_tokens (user_id, token, expires_at) VALUES ($1, $2, $3)",
-		user.ID, tokenString, time.Now().Add(h.tokenDuration),
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store token"})
+	if h.requireVerification && user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-		"expires_in": h.tokenDuration.Seconds(),
-	})
+	// With 2FA the session only starts once a code is given
+	if user.TOTPEnabledAt != nil {
+		h.startChallenge(c, user.ID)
+		return
+	}
+
+	// Start a session and issue its tokens
+	if h.startSession(c, user.ID) {
+		h.clearLoginFailures(ctx, req.Email)
+	}
 }
 
-func (h *AuthHandler) GenerateToken(userID string) (string, error) {
+// GenerateToken signs a token for userID, returning it with its jti.
+func (h *AuthHandler) GenerateToken(userID string) (string, string, error) {
 	tokenID := make([]byte, 16)
 	if _, err := rand.Read(tokenID); err != nil {
-		return "", err
+		return "", "", err
 	}
+	jti := hex.EncodeToString(tokenID)
 
 	claims := jwt.MapClaims{
 		"sub": userID,
-		"jti": hex.EncodeToString(tokenID),
+		"jti": jti,
 		"exp": time.Now().Add(h.tokenDuration).Unix(),
 		"iat": time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(h.jwtSecret)
+	tokenString, err := h.keys.Sign(context.Background(), claims)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, jti, nil
 }
 
+// VerifyToken checks a token was signed by one of our keys (RS256 or
+// EdDSA, matched by kid) and hasn't expired.
 func (h *AuthHandler) VerifyToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return h.jwtSecret, nil
-	})
+	token, err := h.keys.Parse(tokenString)
 
 	if err != nil {
 		return nil, err
@@ -180,11 +336,14 @@ func (h *AuthHandler) VerifyToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-func (h *AuthHandler) GetJWTSecret() []byte {
-	return h.jwtSecret
+// Keys returns the key set tokens are signed and verified with.
+func (h *AuthHandler) Keys() *signing.KeySet {
+	return h.keys
 }
 
-func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts a session's access token, or an API key holding
+// one of scopes. With no scopes only sessions are accepted.
+func (h *AuthHandler) AuthMiddleware(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := extractToken(c)
 		if tokenString == "" {
@@ -192,6 +351,15 @@ func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if isAPIKey(tokenString) {
+			if len(scopes) == 0 {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this endpoint requires a login session"})
+				return
+			}
+			h.authenticateAPIKey(c, tokenString, scopes)
+			return
+		}
+
 		token, err := h.VerifyToken(tokenString)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
@@ -210,18 +378,15 @@ func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Verify token exists in database
-		var count int
-		err = h.db.Get(&count,
-			"SELECT COUNT(*) FROM auth_tokens WHERE token = $1 AND user_id = $2 AND expires_at > NOW()",
-			tokenString, userID,
-		)
-		if err != nil || count == 0 {
+		// Expiry is checked by VerifyToken; revocation is looked up in Redis
+		tokenID, ok := claims["jti"].(string)
+		if !ok || tokenID == "" || h.isRevoked(c.Request.Context(), tokenID) {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			return
 		}
 
 		c.Set("userID", userID)
+		c.Set("tokenID", tokenID)
 		c.Next()
 	}
 }
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/mailer"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Emailed tokens are single use and stored hashed in user_tokens, one live
+// token per user and purpose.
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// createUserToken replaces any unused token the user has for purpose.
+func (h *AuthHandler) createUserToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)`, userID, purpose, hash, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// consumeUserToken marks a live token used and returns its user. It
+// returns sql.ErrNoRows for unknown, expired or already used tokens.
+func consumeUserToken(ctx context.Context, tx *sqlx.Tx, token, purpose string) (string, error) {
+	var userID string
+	err := tx.GetContext(ctx, &userID, `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, hashToken(token), purpose)
+	return userID, err
+}
+
+// link builds a URL to the client app carrying a token.
+func (h *AuthHandler) link(path, token string) string {
+	return h.appBaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail renders and sends a templated email, logging failures; it runs
+// in the background so responses don't wait on (or reveal) delivery.
+func (h *AuthHandler) sendMail(template, to string, data gin.H) {
+	ctx := context.Background()
+	data["Email"] = to
+	msg, err := mailer.Render(template, to, data)
+	if err == nil {
+		err = h.mailer.Send(ctx, msg)
+	}
+	if err != nil {
+		log.Printf("mail %s to %s: %v", template, to, err)
+	}
+}
+
+func (h *AuthHandler) sendVerification(userID, email string) {
+	token, err := h.createUserToken(context.Background(), userID, purposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		log.Printf("verification token for %s: %v", userID, err)
+		return
+	}
+	h.sendMail("verify_email", email, gin.H{
+		"Link":      h.link("/verify-email", token),
+		"ExpiresIn": "48 hours",
+	})
+}
+
+func (h *AuthHandler) sendPasswordReset(userID, email string) {
+	token, err := h.createUserToken(context.Background(), userID, purposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		log.Printf("reset token for %s: %v", userID, err)
+		return
+	}
+	h.sendMail("reset_password", email, gin.H{
+		"Link":      h.link("/reset-password", token),
+		"ExpiresIn": "1 hour",
+	})
+}
+
+// VerifyEmail confirms the address a verification token was sent to.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(ctx, tx, req.Token, purposeVerifyEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1`, userID)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified successfully"})
+}
+
+// ResendVerification mails a new verification link. The response is the
+// same whether or not the address belongs to an unverified account.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var userID string
+	err := h.db.Get(&userID, `
+		SELECT id FROM users WHERE email = $1 AND email_verified_at IS NULL`, req.Email)
+	if err == nil {
+		go h.sendVerification(userID, req.Email)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists and is unverified, a verification email has been sent"})
+}
+
+// ForgotPassword mails a password reset link. The response is the same
+// whether or not the address has an account.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var userID string
+	if err := h.db.Get(&userID, `SELECT id FROM users WHERE email = $1`, req.Email); err == nil {
+		go h.sendPasswordReset(userID, req.Email)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists, a password reset email has been sent"})
+}
+
+// ResetPassword sets a new password using a reset token, signs the user
+// out everywhere and lifts any login lockout. Following the emailed link
+// also proves the address.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(ctx, tx, req.Token, purposeResetPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	var email string
+	err = tx.GetContext(ctx, &email, `
+		UPDATE users
+		SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $2
+		RETURNING email`, string(hashedPassword), userID)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	if _, err := h.revokeSessions(ctx, userID, "", ""); err != nil {
+		log.Printf("revoke sessions for %s after password reset: %v", userID, err)
+	}
+	// A new password ends any lockout the old one was under attack in
+	h.clearLoginFailures(ctx, email)
+	go h.sendMail("password_changed", email, gin.H{})
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset successfully"})
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public signing keys so other services can verify
+// access tokens themselves.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keys.JWKS()})
+}
+
+// RunKeyRotation creates signing keys as they fall due and keeps this
+// instance's key set in step with the others.
+func (h *AuthHandler) RunKeyRotation(ctx context.Context, interval time.Duration) {
+	h.keys.Run(ctx, interval)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Failed logins are counted in Redis per account (by email, known or not,
+// so lockouts don't reveal which accounts exist) and per client IP. Past a
+// few free failures each one adds an exponentially growing delay before
+// the next attempt; at the limit the account or IP is locked for a while.
+// A locked account's owner is emailed a link that unlocks it.
+const (
+	purposeUnlockAccount = "unlock_account"
+	unlockAccountTTL     = 24 * time.Hour
+
+	loginFailureWindow = time.Hour
+	loginBackoffBase   = time.Second
+	loginBackoffMax    = 5 * time.Minute
+)
+
+// loginLimits are the thresholds for one kind of counter.
+type loginLimits struct {
+	// backoffAfter failures are allowed before delays start
+	backoffAfter int64
+	// lockAfter failures lock the account or IP out
+	lockAfter int64
+}
+
+func loginKey(kind, scope, id string) string {
+	return "login_" + kind + ":" + scope + ":" + id
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBlocked returns how long a login for email from ip has to wait, and
+// whether that is because the account is locked. Redis errors let the
+// attempt through.
+func (h *AuthHandler) loginBlocked(ctx context.Context, email, ip string) (time.Duration, bool) {
+	email = normalizeEmail(email)
+	pipe := h.redisClient.Pipeline()
+	accountLock := pipe.PTTL(ctx, loginKey("locked", "account", email))
+	ipLock := pipe.PTTL(ctx, loginKey("locked", "ip", ip))
+	accountBackoff := pipe.PTTL(ctx, loginKey("backoff", "account", email))
+	ipBackoff := pipe.PTTL(ctx, loginKey("backoff", "ip", ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("check login throttle: %v", err)
+		return 0, false
+	}
+
+	if ttl := accountLock.Val(); ttl > 0 {
+		return ttl, true
+	}
+	var wait time.Duration
+	for _, ttl := range []time.Duration{ipLock.Val(), accountBackoff.Val(), ipBackoff.Val()} {
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait, false
+}
+
+// recordLoginFailure counts a failed login for email from ip, setting a
+// backoff or lockout as the counts grow. userID is empty for unknown
+// emails.
+func (h *AuthHandler) recordLoginFailure(ctx context.Context, email, ip, userID string) {
+	email = normalizeEmail(email)
+	if h.countLoginFailure(ctx, "account", email, h.accountLimits) && userID != "" {
+		go h.sendUnlock(userID, email)
+	}
+	h.countLoginFailure(ctx, "ip", ip, h.ipLimits)
+}
+
+// countLoginFailure bumps one counter and reports whether it just locked.
+func (h *AuthHandler) countLoginFailure(ctx context.Context, scope, id string, limits loginLimits) bool {
+	key := loginKey("failures", scope, id)
+	n, err := h.redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		log.Printf("count login failure: %v", err)
+		return false
+	}
+	h.redisClient.Expire(ctx, key, loginFailureWindow)
+
+	if n >= limits.lockAfter {
+		// The count starts over once the lock expires
+		h.redisClient.Set(ctx, loginKey("locked", scope, id), 1, h.lockoutDuration)
+		h.redisClient.Del(ctx, key, loginKey("backoff", scope, id))
+		return true
+	}
+	if n > limits.backoffAfter {
+		delay := loginBackoffMax
+		if shift := n - limits.backoffAfter - 1; shift < 20 && loginBackoffBase<<shift < delay {
+			delay = loginBackoffBase << shift
+		}
+		h.redisClient.Set(ctx, loginKey("backoff", scope, id), 1, delay)
+	}
+	return false
+}
+
+// clearLoginFailures resets an account's counters and lifts any lockout
+// or backoff on it. IP counters are left to expire.
+func (h *AuthHandler) clearLoginFailures(ctx context.Context, email string) {
+	email = normalizeEmail(email)
+	err := h.redisClient.Del(ctx,
+		loginKey("failures", "account", email),
+		loginKey("backoff", "account", email),
+		loginKey("locked", "account", email),
+	).Err()
+	if err != nil {
+		log.Printf("clear login failures: %v", err)
+	}
+}
+
+// tooManyAttempts writes the response for a throttled login.
+func tooManyAttempts(c *gin.Context, wait time.Duration, locked bool) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	if locked {
+		c.JSON(http.StatusLocked, gin.H{
+			"error":       "account temporarily locked after too many failed login attempts",
+			"retry_after": seconds,
+		})
+		return
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "too many failed login attempts",
+		"retry_after": seconds,
+	})
+}
+
+func (h *AuthHandler) sendUnlock(userID, email string) {
+	token, err := h.createUserToken(context.Background(), userID, purposeUnlockAccount, unlockAccountTTL)
+	if err != nil {
+		log.Printf("unlock token for %s: %v", userID, err)
+		return
+	}
+	h.sendMail("account_locked", email, gin.H{
+		"Link":      h.link("/unlock-account", token),
+		"LockedFor": strconv.Itoa(int(h.lockoutDuration.Minutes())) + " minutes",
+		"ExpiresIn": "24 hours",
+	})
+}
+
+// UnlockAccount lifts a lockout using the token from the lockout email.
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(ctx, tx, req.Token, purposeUnlockAccount)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	var email string
+	if err := tx.GetContext(ctx, &email, `SELECT email FROM users WHERE id = $1`, userID); err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+
+	h.clearLoginFailures(ctx, email)
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
+
+// AdminUnlockUser lifts a login lockout on a user's account.
+func (h *AuthHandler) AdminUnlockUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	h.clearLoginFailures(c.Request.Context(), user.Email)
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
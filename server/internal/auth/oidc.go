@@ -0,0 +1,455 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/oauth2"
+)
+
+// OIDC login uses the authorization code flow with PKCE. The login (or
+// link) endpoint keeps the state, nonce and code verifier in Redis and
+// redirects to the provider, setting the state in an HttpOnly cookie too;
+// the callback checks the cookie, exchanges the code, verifies the ID
+// token and starts a normal session.
+
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie binds a flow to the browser that started it, so a
+// callback carrying someone else's state (login CSRF) is refused.
+const oidcStateCookie = "oidc_state"
+
+// roleRank orders roles so the most privileged mapped group wins.
+var roleRank = map[string]int{"user": 0, "moderator": 1, "admin": 2}
+
+// rolesBelow lists the roles less privileged than role.
+func rolesBelow(role string) []string {
+	var below []string
+	for r, rank := range roleRank {
+		if rank < roleRank[role] {
+			below = append(below, r)
+		}
+	}
+	return below
+}
+
+type oidcConfig struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	autoProvision bool
+	groupsClaim   string
+	// roleMap maps IdP groups to roles; when empty roles aren't managed
+	roleMap map[string]string
+
+	// The provider is discovered on first use so the server can start
+	// while the IdP is unreachable
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// oidcConfigFromEnv reads OIDC_* settings; it returns nil when
+// OIDC_ISSUER is unset.
+func oidcConfigFromEnv() (*oidcConfig, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	cfg := &oidcConfig{
+		issuer:        issuer,
+		clientID:      os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:        []string{oidc.ScopeOpenID, "email", "profile"},
+		autoProvision: os.Getenv("OIDC_AUTO_PROVISION") == "true",
+		groupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		roleMap:       map[string]string{},
+	}
+	if cfg.clientID == "" || cfg.redirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER is")
+	}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	if cfg.groupsClaim == "" {
+		cfg.groupsClaim = "groups"
+	}
+
+	// OIDC_ROLE_MAP is "group=role,group=role"
+	if v := os.Getenv("OIDC_ROLE_MAP"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if _, known := roleRank[role]; !ok || group == "" || !known {
+				return nil, fmt.Errorf("OIDC_ROLE_MAP: invalid entry %q", pair)
+			}
+			cfg.roleMap[group] = role
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *oidcConfig) discover(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.provider == nil {
+		provider, err := oidc.NewProvider(ctx, cfg.issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.provider = provider
+	}
+	return cfg.provider, &oauth2.Config{
+		ClientID:     cfg.clientID,
+		ClientSecret: cfg.clientSecret,
+		RedirectURL:  cfg.redirectURL,
+		Endpoint:     cfg.provider.Endpoint(),
+		Scopes:       cfg.scopes,
+	}, nil
+}
+
+// mapRole returns the most privileged role among the user's groups.
+func (cfg *oidcConfig) mapRole(groups []string) string {
+	role := "user"
+	for _, group := range groups {
+		if mapped, ok := cfg.roleMap[group]; ok && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// oidcState is what the callback needs from the request that started the
+// flow. LinkUserID is set when a logged-in user is linking an identity.
+type oidcState struct {
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+}
+
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// authorizationURL starts a flow and returns where to send the browser.
+func (h *AuthHandler) authorizationURL(c *gin.Context, linkUserID string) (string, error) {
+	ctx := c.Request.Context()
+	_, config, err := h.oidc.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	st := oidcState{Verifier: oauth2.GenerateVerifier(), Nonce: nonce, LinkUserID: linkUserID}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	if err := h.redisClient.Set(ctx, oidcStateKey(state), data, oidcStateTTL).Err(); err != nil {
+		return "", err
+	}
+	h.setStateCookie(c, state, int(oidcStateTTL.Seconds()))
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(st.Verifier)), nil
+}
+
+// setStateCookie sets the flow's state cookie; a negative maxAge clears it.
+func (h *AuthHandler) setStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := strings.HasPrefix(h.oidc.redirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/oidc", "", secure, true)
+}
+
+func (h *AuthHandler) oidcEnabled(c *gin.Context) bool {
+	if h.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return false
+	}
+	return true
+}
+
+// OIDCLogin redirects the browser to the identity provider.
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if !h.oidcEnabled(c) {
+		return
+	}
+	authURL, err := h.authorizationURL(c, "")
+	if err != nil {
+		log.Printf("oidc login: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLink returns a provider URL that, once completed, links the
+// identity to the caller's account. It is JSON rather than a redirect
+// because the caller authenticates with a bearer token.
+func (h *AuthHandler) OIDCLink(c *gin.Context) {
+	if !h.oidcEnabled(c) {
+		return
+	}
+	authURL, err := h.authorizationURL(c, c.GetString("userID"))
+	if err != nil {
+		log.Printf("oidc link: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// OIDCCallback completes the flow with the code and state the provider
+// sent back, then starts a session for the linked user. Whoever serves
+// OIDC_REDIRECT_URL forwards its query string here from the same browser,
+// with its cookies.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if !h.oidcEnabled(c) {
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "identity provider returned " + errCode})
+		return
+	}
+
+	// The state must be the one this browser was given
+	state := c.Query("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired state"})
+		return
+	}
+	h.setStateCookie(c, "", -1)
+
+	ctx := c.Request.Context()
+	data, err := h.redisClient.GetDel(ctx, oidcStateKey(state)).Bytes()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired state"})
+		return
+	}
+	var st oidcState
+	if err := json.Unmarshal(data, &st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired state"})
+		return
+	}
+
+	provider, config, err := h.oidc.discover(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+	token, err := config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to exchange authorization code"})
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "provider returned no id_token"})
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: h.oidc.clientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != st.Nonce {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid id_token"})
+		return
+	}
+
+	var claims oidcClaims
+	var all map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil || idToken.Claims(&all) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid id_token claims"})
+		return
+	}
+	claims.Email = strings.ToLower(claims.Email)
+
+	userID, status, err := h.resolveIdentity(ctx, idToken.Issuer, &claims, st.LinkUserID)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("oidc callback: %v", err)
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if st.LinkUserID != "" {
+		c.JSON(http.StatusOK, gin.H{"message": "identity linked successfully"})
+		return
+	}
+
+	// Groups only ever raise the role, so a role granted by an admin
+	// survives a login with fewer groups; linking leaves it alone
+	if len(h.oidc.roleMap) > 0 {
+		role := h.oidc.mapRole(claimStrings(all[h.oidc.groupsClaim]))
+		if below := rolesBelow(role); len(below) > 0 {
+			_, err := h.db.ExecContext(ctx, `UPDATE users SET role = $1 WHERE id = $2 AND role = ANY($3)`, role, userID, pq.Array(below))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+				return
+			}
+		}
+	}
+
+	// The provider has authenticated the user, including any second
+	// factor it enforces
+	h.startSession(c, userID)
+}
+
+// claimStrings reads a groups claim, which may be a list or one string.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// resolveIdentity finds or creates the local user for an external
+// identity. An identity not seen before is linked to linkUserID when
+// linking, else to the account with the same verified email, else to a
+// new account if auto-provisioning is on. Accounts with a password or 2FA
+// are never linked by email; their owner links from POST /oidc/link.
+// Errors carry the status to send.
+func (h *AuthHandler) resolveIdentity(ctx context.Context, issuer string, claims *oidcClaims, linkUserID string) (string, int, error) {
+	var userID string
+	err := h.db.GetContext(ctx, &userID, `
+		UPDATE user_identities SET last_login_at = NOW(), email = $3
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id`, issuer, claims.Subject, claims.Email)
+	switch {
+	case err == nil:
+		if linkUserID != "" && linkUserID != userID {
+			return "", http.StatusConflict, errors.New("identity is already linked to another account")
+		}
+		return userID, http.StatusOK, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return "", http.StatusInternalServerError, err
+	}
+
+	switch {
+	case linkUserID != "":
+		userID = linkUserID
+	case claims.Email != "" && claims.EmailVerified:
+		var existing struct {
+			ID        string `db:"id"`
+			Protected bool   `db:"protected"`
+		}
+		err = h.db.GetContext(ctx, &existing, `
+			SELECT id, password_hash <> '' OR totp_enabled_at IS NOT NULL AS protected
+			FROM users WHERE lower(email) = $1`, claims.Email)
+		if err == nil && existing.Protected {
+			return "", http.StatusConflict, errors.New("an account with this email already exists; sign in and link this identity from your account")
+		}
+		userID = existing.ID
+		if errors.Is(err, sql.ErrNoRows) && h.oidc.autoProvision {
+			err = h.db.GetContext(ctx, &userID, `
+				INSERT INTO users (email, password_hash, email_verified_at)
+				VALUES ($1, '', NOW())
+				RETURNING id`, claims.Email)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", http.StatusForbidden, errors.New("no account for this identity")
+		}
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+	default:
+		return "", http.StatusForbidden, errors.New("identity provider did not supply a verified email")
+	}
+
+	_, err = h.db.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())`, userID, issuer, claims.Subject, claims.Email)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return userID, http.StatusOK, nil
+}
+
+// ListIdentities lists the external identities linked to the caller.
+func (h *AuthHandler) ListIdentities(c *gin.Context) {
+	type identity struct {
+		ID          string     `json:"id" db:"id"`
+		Issuer      string     `json:"issuer" db:"issuer"`
+		Subject     string     `json:"subject" db:"subject"`
+		Email       *string    `json:"email" db:"email"`
+		CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+		LastLoginAt *time.Time `json:"last_login_at" db:"last_login_at"`
+	}
+	identities := []identity{}
+	err := h.db.Select(&identities, `
+		SELECT id, issuer, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list identities"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity removes a linked identity, unless it is the only way an
+// account without a password can sign in.
+func (h *AuthHandler) UnlinkIdentity(c *gin.Context) {
+	userID := c.GetString("userID")
+	result, err := h.db.Exec(`
+		DELETE FROM user_identities i
+		WHERE i.id::text = $1 AND i.user_id = $2
+		  AND ((SELECT password_hash FROM users WHERE id = $2) <> ''
+		       OR (SELECT COUNT(*) FROM user_identities WHERE user_id = $2) > 1)`,
+		c.Param("identity_id"), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlink identity"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity not found, or it is your only way to sign in"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "identity unlinked successfully"})
+}
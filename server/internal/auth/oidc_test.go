@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/redistest"
+	"github.com/YogendrasinghRathod/server/internal/sqltest"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "fileshare"
+
+// mockProvider is an OpenID provider serving discovery, a JWKS and a
+// token endpoint that answers any code with an ID token for claims.
+type mockProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T, claims jwt.MapClaims) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, claims: claims}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") == "" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": p.URL,
+			"aud": testClientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+type oidcTest struct {
+	handler *AuthHandler
+	db      *sqltest.DB
+	redis   *redistest.Server
+}
+
+func newOIDCTest(t *testing.T, provider *mockProvider) *oidcTest {
+	t.Helper()
+	db, fakeDB := sqltest.Open(t)
+	client, fakeRedis := redistest.NewClient(t)
+	return &oidcTest{
+		handler: &AuthHandler{
+			db:          db,
+			redisClient: client,
+			oidc: &oidcConfig{
+				issuer:      provider.URL,
+				clientID:    testClientID,
+				redirectURL: "https://app.example.com/oidc/callback",
+				groupsClaim: "groups",
+				roleMap:     map[string]string{},
+			},
+		},
+		db:    fakeDB,
+		redis: fakeRedis,
+	}
+}
+
+// callback stores st under state and runs the callback with the given
+// state parameter and cookie.
+func (o *oidcTest) callback(t *testing.T, st oidcState, state, cookie string) *httptest.ResponseRecorder {
+	t.Helper()
+	data, _ := json.Marshal(st)
+	o.redis.Set(oidcStateKey("state"), string(data))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	if cookie != "" {
+		c.Request.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+	}
+	o.handler.OIDCCallback(c)
+	return w
+}
+
+// startSessionStops makes startSession answer 403 "account disabled",
+// which shows the callback got as far as signing the user in.
+func (o *oidcTest) startSessionStops() {
+	o.db.On("SELECT disabled_at IS NOT NULL", sqltest.Row([]string{"disabled"}, true))
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider := newMockProvider(t, jwt.MapClaims{"sub": "s1", "nonce": "n"})
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+	}{
+		{"no cookie", "state", ""},
+		{"cookie for another flow", "state", "other"},
+		{"no state", "", "state"},
+	}
+	for _, tt := range tests {
+		o := newOIDCTest(t, provider)
+		w := o.callback(t, oidcState{Verifier: "v", Nonce: "n"}, tt.state, tt.cookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tt.name, w.Code)
+		}
+		if _, ok := o.redis.Get(oidcStateKey("state")); !ok {
+			t.Errorf("%s: state was consumed", tt.name)
+		}
+		if len(o.db.Ran("user_identities")) != 0 {
+			t.Errorf("%s: identity was resolved", tt.name)
+		}
+	}
+}
+
+func TestOIDCCallbackNonce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider := newMockProvider(t, jwt.MapClaims{"sub": "s1", "nonce": "replayed", "email": "a@example.com", "email_verified": true})
+	o := newOIDCTest(t, provider)
+
+	w := o.callback(t, oidcState{Verifier: "v", Nonce: "n"}, "state", "state")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid id_token") {
+		t.Errorf("status = %d, want 401 for the id_token: %s", w.Code, w.Body)
+	}
+	if len(o.db.Ran("user_identities")) != 0 {
+		t.Error("identity was resolved for a token with the wrong nonce")
+	}
+}
+
+func TestOIDCCallbackEmailLinking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name          string
+		existing      []driver.Value // id, protected; nil when no account has the email
+		autoProvision bool
+		want          int
+		wantErr       string
+		provisioned   bool
+		linked        string
+	}{
+		{"password or 2FA account", []driver.Value{"u1", true}, false, http.StatusConflict, "already exists", false, ""},
+		{"passwordless account", []driver.Value{"u1", false}, false, http.StatusForbidden, "account disabled", false, "u1"},
+		{"unknown, auto-provisioning", nil, true, http.StatusForbidden, "account disabled", true, "u2"},
+		{"unknown, no auto-provisioning", nil, false, http.StatusForbidden, "no account", false, ""},
+	}
+	for _, tt := range tests {
+		provider := newMockProvider(t, jwt.MapClaims{"sub": "s1", "nonce": "n", "email": "A@Example.com", "email_verified": true})
+		o := newOIDCTest(t, provider)
+		o.handler.oidc.autoProvision = tt.autoProvision
+		if tt.existing != nil {
+			o.db.On("FROM users WHERE lower(email)", sqltest.Row([]string{"id", "protected"}, tt.existing...))
+		}
+		o.db.On("INSERT INTO users", sqltest.Row([]string{"id"}, "u2"))
+		o.startSessionStops()
+
+		w := o.callback(t, oidcState{Verifier: "v", Nonce: "n"}, "state", "state")
+		if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.wantErr) {
+			t.Errorf("%s: status = %d, want %d %q: %s", tt.name, w.Code, tt.want, tt.wantErr, w.Body)
+		}
+
+		provisioned := o.db.Ran("INSERT INTO users")
+		if (len(provisioned) > 0) != tt.provisioned {
+			t.Errorf("%s: provisioned = %v, want %v", tt.name, len(provisioned) > 0, tt.provisioned)
+		}
+		if len(provisioned) > 0 && provisioned[0].Args[0] != "a@example.com" {
+			t.Errorf("%s: provisioned with email %v", tt.name, provisioned[0].Args[0])
+		}
+
+		linked := o.db.Ran("INSERT INTO user_identities")
+		switch {
+		case tt.linked == "" && len(linked) != 0:
+			t.Errorf("%s: identity was linked", tt.name)
+		case tt.linked != "" && (len(linked) != 1 || linked[0].Args[0] != tt.linked):
+			t.Errorf("%s: linked %v, want user %s", tt.name, linked, tt.linked)
+		}
+	}
+}
+
+func TestOIDCCallbackRoleMap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		groups []string
+		link   bool
+		want   string // role set, "" for none
+	}{
+		{"highest group wins", []string{"staff", "admins", "other"}, false, "admin"},
+		{"one group", []string{"staff"}, false, "moderator"},
+		{"no mapped group", []string{"other"}, false, ""},
+		{"linking", []string{"admins"}, true, ""},
+	}
+	for _, tt := range tests {
+		provider := newMockProvider(t, jwt.MapClaims{"sub": "s1", "nonce": "n", "groups": tt.groups})
+		o := newOIDCTest(t, provider)
+		o.handler.oidc.roleMap = map[string]string{"staff": "moderator", "admins": "admin"}
+		o.db.On("UPDATE user_identities", sqltest.Row([]string{"user_id"}, "u1"))
+		o.startSessionStops()
+
+		st := oidcState{Verifier: "v", Nonce: "n"}
+		want, wantBody := http.StatusForbidden, "account disabled"
+		if tt.link {
+			st.LinkUserID, want, wantBody = "u1", http.StatusOK, "linked"
+		}
+		w := o.callback(t, st, "state", "state")
+		if w.Code != want || !strings.Contains(w.Body.String(), wantBody) {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, want, w.Body)
+		}
+
+		updates := o.db.Ran("UPDATE users SET role")
+		if tt.want == "" {
+			if len(updates) != 0 {
+				t.Errorf("%s: role changed: %v", tt.name, updates)
+			}
+			continue
+		}
+		if len(updates) != 1 || updates[0].Args[0] != tt.want || updates[0].Args[1] != "u1" {
+			t.Errorf("%s: updates = %v, want role %s", tt.name, updates, tt.want)
+			continue
+		}
+		// Only less privileged roles are raised, never lowered
+		below := fmt.Sprint(updates[0].Args[2])
+		if strings.Contains(below, tt.want) || !strings.Contains(below, "user") {
+			t.Errorf("%s: raises roles %s", tt.name, below)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles, from least to most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by RequirePermission. Ordinary file access is
+// governed by ownership and grants, not by these.
+const (
+	PermViewUsers        = "users:view"
+	PermManageUsers      = "users:manage"
+	PermViewAnyFile      = "files:view_any"
+	PermRevokeAnyShare   = "shares:revoke_any"
+	PermManageQuarantine = "quarantine:manage"
+)
+
+var rolePermissions = map[string]map[string]bool{
+	RoleUser: {},
+	RoleModerator: {
+		PermViewUsers:        true,
+		PermViewAnyFile:      true,
+		PermRevokeAnyShare:   true,
+		PermManageQuarantine: true,
+	},
+	RoleAdmin: {
+		PermViewUsers:        true,
+		PermManageUsers:      true,
+		PermViewAnyFile:      true,
+		PermRevokeAnyShare:   true,
+		PermManageQuarantine: true,
+	},
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role, perm string) bool {
+	return rolePermissions[role][perm]
+}
+
+// RequirePermission only lets through users whose role grants perm. It
+// must run after AuthMiddleware, and sets "role" in the context.
+func (h *AuthHandler) RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role string
+		if err := h.db.Get(&role, "SELECT role FROM users WHERE id = $1", c.GetString("userID")); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+		if !HasPermission(role, perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+		c.Set("role", role)
+		c.Next()
+	}
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Revoked tokens are listed in Redis until they would have expired anyway,
+// so AuthMiddleware can reject them without a database query.
+func revokedTokenKey(jti string) string {
+	return "revoked_token:" + jti
+}
+
+type session struct {
+	ID              string     `json:"id" db:"id"`
+	Device          string     `json:"device" db:"-"`
+	UserAgent       *string    `json:"user_agent" db:"user_agent"`
+	IPAddress       *string    `json:"ip_address" db:"ip_address"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	LastRefreshedAt *time.Time `json:"last_refreshed_at" db:"last_refreshed_at"`
+	ExpiresAt       time.Time  `json:"expires_at" db:"expires_at"`
+	Current         bool       `json:"current" db:"-"`
+}
+
+// describeDevice turns a User-Agent into a short "Browser on OS" label.
+func describeDevice(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+// isRevoked reports whether a token has been revoked. Redis is the fast
+// path; if it is unreachable the session row is checked instead.
+func (h *AuthHandler) isRevoked(ctx context.Context, jti string) bool {
+	n, err := h.redisClient.Exists(ctx, revokedTokenKey(jti)).Result()
+	if err == nil {
+		return n > 0
+	}
+
+	var active bool
+	err = h.db.GetContext(ctx, &active, `
+		SELECT revoked_at IS NULL AND expires_at > NOW() FROM auth_tokens WHERE jti = $1`, jti)
+	return err != nil || !active
+}
+
+// currentSession returns the session the request's access token belongs to.
+func (h *AuthHandler) currentSession(c *gin.Context) (string, error) {
+	var sessionID sql.NullString
+	err := h.db.GetContext(c.Request.Context(), &sessionID,
+		`SELECT session_id FROM auth_tokens WHERE jti = $1`, c.GetString("tokenID"))
+	return sessionID.String, err
+}
+
+// revokeSessions ends the user's matching live sessions: their refresh
+// tokens stop working and their access tokens are listed in Redis as
+// revoked. sessionID, when set, selects one session; except, when set, is a
+// session to leave alone.
+func (h *AuthHandler) revokeSessions(ctx context.Context, userID, sessionID, except string) (int, error) {
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sessions []string
+	err = tx.SelectContext(ctx, &sessions, `
+		UPDATE auth_sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		  AND ($2 = '' OR id::text = $2) AND ($3 = '' OR id::text <> $3)
+		RETURNING id`, userID, sessionID, except)
+	if err != nil {
+		return 0, err
+	}
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+
+	type revoked struct {
+		JTI       string    `db:"jti"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	var tokens []revoked
+	err = tx.SelectContext(ctx, &tokens, `
+		UPDATE auth_tokens SET revoked_at = NOW()
+		WHERE session_id = ANY($1) AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING jti, expires_at`, pq.Array(sessions))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, t := range tokens {
+		if ttl := time.Until(t.ExpiresAt); ttl > 0 {
+			h.redisClient.Set(ctx, revokedTokenKey(t.JTI), "1", ttl)
+		}
+	}
+	return len(sessions), nil
+}
+
+// Logout ends the session the request was made with, including its
+// refresh token.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	sessionID, err := h.currentSession(c)
+	if err != nil || sessionID == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+	if _, err := h.revokeSessions(c.Request.Context(), c.GetString("userID"), sessionID, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+}
+
+// ListSessions lists the caller's live sessions, newest first.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	current, _ := h.currentSession(c)
+
+	sessions := []session{}
+	err := h.db.Select(&sessions, `
+		SELECT id, user_agent, host(ip_address) AS ip_address, created_at, last_refreshed_at, expires_at
+		FROM auth_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	for i := range sessions {
+		s := &sessions[i]
+		ua := ""
+		if s.UserAgent != nil {
+			ua = *s.UserAgent
+		}
+		s.Device = describeDevice(ua)
+		s.Current = s.ID == current
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession ends one of the caller's sessions by ID.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	n, err := h.revokeSessions(c.Request.Context(), c.GetString("userID"), c.Param("session_id"), "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked successfully"})
+}
+
+// RevokeOtherSessions ends every session of the caller's except the
+// current one.
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	current, err := h.currentSession(c)
+	if err != nil || current == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	n, err := h.revokeSessions(c.Request.Context(), c.GetString("userID"), "", current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": n, "message": "other sessions revoked successfully"})
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Access tokens are short-lived JWTs. Refresh tokens are opaque, single
+// use and rotated on every refresh; presenting one that was already used
+// means it leaked, so the whole session it belongs to is revoked.
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// tokenPair is what a client holds for a session.
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// newOpaqueToken returns a random token for the client to hold and the
+// hash stored in its place.
+func newOpaqueToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens signs an access token and creates a refresh token for an
+// existing session, recording both in tx. It returns the refresh token's ID
+// so a rotated predecessor can point at it.
+func (h *AuthHandler) issueTokens(ctx context.Context, tx *sqlx.Tx, c *gin.Context, userID, sessionID string) (*tokenPair, string, error) {
+	accessToken, tokenID, err := h.GenerateToken(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth_tokens (user_id, token, expires_at, jti, user_agent, ip_address, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		userID, accessToken, time.Now().Add(h.tokenDuration),
+		tokenID, c.Request.UserAgent(), c.ClientIP(), sessionID,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	refreshToken, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	var refreshID string
+	err = tx.GetContext(ctx, &refreshID, `
+		INSERT INTO refresh_tokens (session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id`, sessionID, hash, time.Now().Add(h.refreshDuration))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, refreshID, nil
+}
+
+func (h *AuthHandler) tokenResponse(tokens *tokenPair) gin.H {
+	return gin.H{
+		"token":              tokens.AccessToken,
+		"expires_in":         h.tokenDuration.Seconds(),
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_in": h.refreshDuration.Seconds(),
+	}
+}
+
+// startSession opens a new session for a user who has just authenticated
+// and writes the token response. It reports whether the session started.
+func (h *AuthHandler) startSession(c *gin.Context, userID string) bool {
+	ctx := c.Request.Context()
+	var disabled bool
+	if err := h.db.GetContext(ctx, &disabled, `SELECT disabled_at IS NOT NULL FROM users WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return false
+	}
+	if disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return false
+	}
+
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return false
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	err = tx.GetContext(ctx, &sessionID, `
+		INSERT INTO auth_sessions (user_id, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, userID, c.Request.UserAgent(), c.ClientIP(), time.Now().Add(h.refreshDuration))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return false
+	}
+
+	tokens, _, err := h.issueTokens(ctx, tx, c, userID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store token"})
+		return false
+	}
+
+	c.JSON(http.StatusOK, h.tokenResponse(tokens))
+	return true
+}
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token in the same session.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokens, err := h.rotateRefreshToken(ctx, c, req.RefreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reuse detected; session revoked"})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.tokenResponse(tokens))
+}
+
+// rotateRefreshToken marks token used and issues its replacement. It
+// returns sql.ErrNoRows for unknown, expired or revoked tokens (or a
+// disabled account) and errRefreshTokenReused, after revoking the
+// session, for used ones.
+func (h *AuthHandler) rotateRefreshToken(ctx context.Context, c *gin.Context, token string) (*tokenPair, error) {
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the token so two concurrent refreshes can't both rotate it
+	var current struct {
+		ID        string       `db:"id"`
+		SessionID string       `db:"session_id"`
+		UserID    string       `db:"user_id"`
+		ExpiresAt time.Time    `db:"expires_at"`
+		UsedAt    sql.NullTime `db:"used_at"`
+		RevokedAt sql.NullTime `db:"revoked_at"`
+	}
+	err = tx.GetContext(ctx, &current, `
+		SELECT r.id, r.session_id, s.user_id, r.expires_at, r.used_at, s.revoked_at
+		FROM refresh_tokens r
+		JOIN auth_sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.user_id
+		WHERE r.token_hash = $1 AND u.disabled_at IS NULL
+		FOR UPDATE OF r`, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if current.RevokedAt.Valid || time.Now().After(current.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	if current.UsedAt.Valid {
+		tx.Rollback()
+		if _, err := h.revokeSessions(ctx, current.UserID, current.SessionID, ""); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	tokens, nextID, err := h.issueTokens(ctx, tx, c, current.UserID, current.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET used_at = NOW(), replaced_by = $1 WHERE id = $2`, nextID, current.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE auth_sessions SET last_refreshed_at = NOW(), expires_at = $1 WHERE id = $2`,
+		time.Now().Add(h.refreshDuration), current.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/totp"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// With TOTP enabled, a correct password only earns a challenge token; the
+// session starts once a code (or a recovery code) is given for it.
+const (
+	totpIssuer = "File Share"
+
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func challengeKey(token string) string {
+	return "mfa_challenge:" + hashToken(token)
+}
+
+// startChallenge records that userID has passed the password step and
+// writes the challenge response.
+func (h *AuthHandler) startChallenge(c *gin.Context, userID string) {
+	token, _, err := newOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor challenge"})
+		return
+	}
+	if err := h.redisClient.Set(c.Request.Context(), challengeKey(token), userID, challengeTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          challengeTTL.Seconds(),
+	})
+}
+
+// checkTOTP validates a code against the user's secret and records its
+// step so it can't be used again. With enabledOnly false the pending secret
+// from setup is checked instead.
+func checkTOTP(ctx context.Context, q sqlx.ExtContext, userID, code string, enabledOnly bool) (bool, error) {
+	var secret sql.NullString
+	err := sqlx.GetContext(ctx, q, &secret, `
+		SELECT totp_secret FROM users
+		WHERE id = $1 AND (totp_enabled_at IS NOT NULL) = $2`, userID, enabledOnly)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !secret.Valid) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	res, err := q.ExecContext(ctx, `
+		UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// useRecoveryCode consumes one of the user's unused recovery codes.
+func useRecoveryCode(ctx context.Context, q sqlx.ExtContext, userID, code string) (bool, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	res, err := q.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hashToken(normalized))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// secondFactor checks a TOTP code or, failing that, a recovery code.
+func secondFactor(ctx context.Context, q sqlx.ExtContext, userID, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return checkTOTP(ctx, q, userID, code, true)
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(ctx, q, userID, recoveryCode)
+	}
+	return false, nil
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns a new
+// set, formatted XXXXX-XXXXX. Only hashes are stored.
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := base32.StdEncoding.EncodeToString(b)[:10]
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashToken(raw))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// LoginTwoFactor completes a login started by Login with a TOTP or
+// recovery code. A challenge allows a few attempts before it is dropped, and
+// each bad code counts as a failed login for the account.
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := challengeKey(req.ChallengeToken)
+	userID, err := h.redisClient.Get(ctx, key).Result()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+
+	// Bad codes count toward the same lockout as bad passwords
+	var email string
+	if err := h.db.GetContext(ctx, &email, `SELECT email FROM users WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	ip := c.ClientIP()
+	if wait, locked := h.loginBlocked(ctx, email, ip); wait > 0 {
+		tooManyAttempts(c, wait, locked)
+		return
+	}
+
+	ok, err := secondFactor(ctx, h.db, userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !ok {
+		h.recordLoginFailure(ctx, email, ip, userID)
+		if n, _ := h.redisClient.Incr(ctx, key+":attempts").Result(); n == 1 {
+			h.redisClient.Expire(ctx, key+":attempts", challengeTTL)
+		} else if n >= maxChallengeAttempts {
+			h.redisClient.Del(ctx, key, key+":attempts")
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	// A challenge is good for one session
+	if n, _ := h.redisClient.Del(ctx, key).Result(); n == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+	h.redisClient.Del(ctx, key+":attempts")
+
+	if h.startSession(c, userID) {
+		h.clearLoginFailures(ctx, email)
+	}
+}
+
+// SetupTwoFactor creates a new secret for the caller and returns it with
+// its otpauth:// URI for a QR code. 2FA is not enforced until
+// EnableTwoFactor confirms a code from it.
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var user struct {
+		Email   string       `db:"email"`
+		Enabled sql.NullTime `db:"totp_enabled_at"`
+	}
+	if err := h.db.Get(&user, `SELECT email, totp_enabled_at FROM users WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if user.Enabled.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	_, err = h.db.Exec(`
+		UPDATE users SET totp_secret = $1, totp_last_step = NULL
+		WHERE id = $2 AND totp_enabled_at IS NULL`, secret, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTwoFactor turns 2FA on once the caller proves their authenticator
+// works, and returns recovery codes. They are shown only this once.
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := checkTOTP(ctx, tx, userID, req.Code, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code; run setup first if you haven't"})
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled_at = NOW() WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes after
+// checking a current TOTP code.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := checkTOTP(ctx, tx, userID, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off given the password and a second factor.
+// When share links require 2FA, owners of active links must revoke them
+// first.
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+
+	var passwordHash string
+	if err := h.db.Get(&passwordHash, `SELECT password_hash FROM users WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+
+	if h.requireShare2FA {
+		var shares int
+		err := h.db.Get(&shares, `
+			SELECT COUNT(*)
+			FROM file_shares s
+			JOIN files f ON f.id = s.file_id
+			WHERE (f.user_id = $1 OR s.created_by = $1)
+			  AND s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > NOW())`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if shares > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "revoke your active share links before disabling two-factor authentication"})
+			return
+		}
+	}
+
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := secondFactor(ctx, tx, userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
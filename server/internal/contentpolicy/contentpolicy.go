@@ -0,0 +1,228 @@
+package contentpolicy
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SniffLen is how much of a file Detect needs to see.
+const SniffLen = 512
+
+func init() {
+	// Not present in every system mime.types
+	mime.AddExtensionType(".md", "text/markdown")
+	mime.AddExtensionType(".markdown", "text/markdown")
+	mime.AddExtensionType(".csv", "text/csv")
+	mime.AddExtensionType(".webp", "image/webp")
+	mime.AddExtensionType(".svg", "image/svg+xml")
+}
+
+// activeTypes are rendered by browsers in a way that can run script in
+// our origin when served inline.
+var activeTypes = map[string]bool{
+	"text/html":                     true,
+	"application/xhtml+xml":         true,
+	"image/svg+xml":                 true,
+	"text/xml":                      true,
+	"application/xml":               true,
+	"text/javascript":               true,
+	"application/javascript":        true,
+	"application/x-javascript":      true,
+	"application/ecmascript":        true,
+	"text/ecmascript":               true,
+	"application/x-shockwave-flash": true,
+}
+
+var activeExtensions = map[string]bool{
+	".html": true, ".htm": true, ".xhtml": true, ".shtml": true, ".svg": true, ".svgz": true,
+	".xml": true, ".xsl": true, ".xslt": true, ".js": true, ".mjs": true, ".swf": true,
+}
+
+func baseType(mimeType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+}
+
+func extension(name string) string {
+	return strings.ToLower(filepath.Ext(name))
+}
+
+// IsActive reports whether content with this type or file name must never
+// be rendered inline.
+func IsActive(mimeType, name string) bool {
+	return activeTypes[baseType(mimeType)] || activeExtensions[extension(name)]
+}
+
+// Detect determines a file's MIME type from its first SniffLen bytes,
+// ignoring whatever the client claimed. The extension only refines
+// generic results (plain text, unknown binary), and never to a type the
+// bytes contradict.
+func Detect(head []byte, name string) string {
+	sniffed := baseType(http.DetectContentType(head))
+	byExt := baseType(mime.TypeByExtension(extension(name)))
+
+	switch sniffed {
+	case "text/plain", "text/xml":
+		if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return "image/svg+xml"
+		}
+		// Trust the extension for text formats and, conservatively, for
+		// anything active
+		if strings.HasPrefix(byExt, "text/") || byExt == "application/json" || IsActive(byExt, "") {
+			return byExt
+		}
+	case "application/octet-stream":
+		if byExt != "" && !strings.HasPrefix(byExt, "text/") && !strings.HasPrefix(byExt, "image/") && !IsActive(byExt, "") {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+// Rejection is returned when content breaks the policy.
+type Rejection struct {
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+// Rules are allow and deny lists of MIME types ("image/png" or
+// "image/*") and extensions (".exe"). Deny entries always win; a
+// non-empty allow list admits only what it names.
+type Rules struct {
+	AllowTypes      []string
+	DenyTypes       []string
+	AllowExtensions []string
+	DenyExtensions  []string
+}
+
+func matchType(patterns []string, mimeType string) bool {
+	for _, p := range patterns {
+		if p == mimeType || (strings.HasSuffix(p, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchExtension(patterns []string, ext string) bool {
+	for _, p := range patterns {
+		if p == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckExtension applies only the extension lists, so uploads can be
+// refused before any content arrives.
+func (r Rules) CheckExtension(name string) error {
+	ext := extension(name)
+	if matchExtension(r.DenyExtensions, ext) {
+		return &Rejection{Reason: fmt.Sprintf("files with extension %q are not allowed", ext)}
+	}
+	if len(r.AllowExtensions) > 0 && !matchExtension(r.AllowExtensions, ext) {
+		return &Rejection{Reason: fmt.Sprintf("files with extension %q are not allowed", ext)}
+	}
+	return nil
+}
+
+// Check applies every list to a detected MIME type and file name.
+func (r Rules) Check(mimeType, name string) error {
+	if err := r.CheckExtension(name); err != nil {
+		return err
+	}
+	mimeType = baseType(mimeType)
+	if matchType(r.DenyTypes, mimeType) {
+		return &Rejection{Reason: fmt.Sprintf("content type %q is not allowed", mimeType)}
+	}
+	if len(r.AllowTypes) > 0 && !matchType(r.AllowTypes, mimeType) {
+		return &Rejection{Reason: fmt.Sprintf("content type %q is not allowed", mimeType)}
+	}
+	return nil
+}
+
+// Policy holds the deployment-wide rules and any per-role overrides.
+type Policy struct {
+	deployment Rules
+	roles      map[string]Rules
+}
+
+// Environment variables read by FromEnv. A role override appends
+// _<ROLE> (e.g. UPLOAD_ALLOWED_TYPES_ADMIN) and replaces the deployment
+// list of the same kind for users with that role.
+const (
+	envAllowTypes      = "UPLOAD_ALLOWED_TYPES"
+	envDenyTypes       = "UPLOAD_DENIED_TYPES"
+	envAllowExtensions = "UPLOAD_ALLOWED_EXTENSIONS"
+	envDenyExtensions  = "UPLOAD_DENIED_EXTENSIONS"
+)
+
+// FromEnv builds a Policy from comma-separated environment lists.
+func FromEnv() *Policy {
+	p := &Policy{
+		deployment: Rules{
+			AllowTypes:      splitList(os.Getenv(envAllowTypes), false),
+			DenyTypes:       splitList(os.Getenv(envDenyTypes), false),
+			AllowExtensions: splitList(os.Getenv(envAllowExtensions), true),
+			DenyExtensions:  splitList(os.Getenv(envDenyExtensions), true),
+		},
+		roles: map[string]Rules{},
+	}
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		for _, env := range []string{envAllowTypes, envDenyTypes, envAllowExtensions, envDenyExtensions} {
+			role, ok := strings.CutPrefix(name, env+"_")
+			if !ok || role == "" {
+				continue
+			}
+			role = strings.ToLower(role)
+			rules, ok := p.roles[role]
+			if !ok {
+				rules = p.deployment
+			}
+			switch env {
+			case envAllowTypes:
+				rules.AllowTypes = splitList(value, false)
+			case envDenyTypes:
+				rules.DenyTypes = splitList(value, false)
+			case envAllowExtensions:
+				rules.AllowExtensions = splitList(value, true)
+			case envDenyExtensions:
+				rules.DenyExtensions = splitList(value, true)
+			}
+			p.roles[role] = rules
+		}
+	}
+	return p
+}
+
+// For returns the rules that apply to users with the given role.
+func (p *Policy) For(role string) Rules {
+	if rules, ok := p.roles[strings.ToLower(role)]; ok {
+		return rules
+	}
+	return p.deployment
+}
+
+func splitList(s string, extensions bool) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if extensions && !strings.HasPrefix(item, ".") {
+			item = "." + item
+		}
+		out = append(out, item)
+	}
+	return out
+}
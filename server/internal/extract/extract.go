@@ -0,0 +1,107 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// MaxPDFSize is the largest PDF Text will parse; bigger files are indexed
+// by name and tags only.
+const MaxPDFSize = 50 << 20
+
+// Supported reports whether Text can pull searchable text out of a file
+// with this MIME type or name.
+func Supported(mimeType, name string) bool {
+	return kind(mimeType, name) != ""
+}
+
+func kind(mimeType, name string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	switch mimeType {
+	case "text/plain", "text/markdown", "text/x-markdown", "text/csv", "application/csv":
+		return "text"
+	case "application/pdf":
+		return "pdf"
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".txt", ".md", ".markdown", ".csv":
+		return "text"
+	case ".pdf":
+		return "pdf"
+	}
+	return ""
+}
+
+// Text extracts up to limit bytes of plain text from r. It returns an
+// empty string for unsupported types.
+func Text(r io.Reader, mimeType, name string, size int64, limit int) (string, error) {
+	switch kind(mimeType, name) {
+	case "text":
+		data, err := io.ReadAll(io.LimitReader(r, int64(limit)))
+		if err != nil {
+			return "", err
+		}
+		return clean(data), nil
+	case "pdf":
+		if size > MaxPDFSize {
+			return "", nil
+		}
+		return pdfText(r, size, limit)
+	default:
+		return "", nil
+	}
+}
+
+// pdfText spools the PDF to a temporary file since the parser needs random
+// access. The parser panics on some malformed files; that is returned as
+// an error so the file is stored without text.
+func pdfText(r io.Reader, size int64, limit int) (text string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			text, err = "", fmt.Errorf("malformed PDF: %v", p)
+		}
+	}()
+
+	tmp, err := os.CreateTemp("", "extract-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if size, err = io.Copy(tmp, r); err != nil {
+		return "", err
+	}
+
+	doc, err := pdf.NewReader(tmp, size)
+	if err != nil {
+		return "", err
+	}
+	plain, err := doc.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(plain, int64(limit)))
+	if err != nil {
+		return "", err
+	}
+	return clean(data), nil
+}
+
+// clean drops invalid UTF-8 and NUL bytes, which Postgres text rejects.
+func clean(data []byte) string {
+	data = bytes.ReplaceAll(data, []byte{0}, nil)
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "")
+}
@@ -0,0 +1,51 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+)
+
+// malformedPDF's xref points object 2 at object 1, which makes the parser
+// panic while loading the page tree.
+const malformedPDF = "%PDF-1.4\n" +
+	"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+	"2 0 obj\n<< /Type /Pages /Kids [] /Count 0 >>\nendobj\n" +
+	"xref\n0 3\n0000000000 65535 f \n0000000009 00000 n \n0000000009 00000 n \n" +
+	"trailer\n<< /Size 3 /Root 1 0 R >>\nstartxref\n110\n%%EOF\n"
+
+func TestTextMalformedPDF(t *testing.T) {
+	text, err := Text(strings.NewReader(malformedPDF), "application/pdf", "bad.pdf", int64(len(malformedPDF)), 1024)
+	if err == nil {
+		t.Fatal("expected an error for a malformed PDF")
+	}
+	if text != "" {
+		t.Errorf("text = %q, want empty", text)
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		input    string
+		limit    int
+		want     string
+	}{
+		{"notes.txt", "text/plain", "hello world", 100, "hello world"},
+		{"notes.md", "application/octet-stream", "# title", 100, "# title"},
+		{"long.txt", "text/plain", "abcdef", 3, "abc"},
+		{"nul.txt", "text/plain", "a\x00b", 100, "ab"},
+		{"bad.txt", "text/plain", "a\xffb", 100, "ab"},
+		{"photo.png", "image/png", "\x89PNG", 100, ""},
+	}
+	for _, tt := range tests {
+		got, err := Text(strings.NewReader(tt.input), tt.mimeType, tt.name, int64(len(tt.input)), tt.limit)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
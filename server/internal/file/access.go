@@ -0,0 +1,157 @@
+package file
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type accessLevel int
+
+const (
+	accessView accessLevel = iota
+	accessEdit
+	accessShare
+	accessOwner
+)
+
+// permissionFlags is what a user may do with a file or folder, either as
+// its owner or through a permissions grant.
+type permissionFlags struct {
+	IsOwner  bool `db:"is_owner"`
+	CanView  bool `db:"can_view"`
+	CanEdit  bool `db:"can_edit"`
+	CanShare bool `db:"can_share"`
+}
+
+func (p permissionFlags) allows(level accessLevel) bool {
+	if p.IsOwner {
+		return true
+	}
+	switch level {
+	case accessView:
+		return p.CanView || p.CanEdit
+	case accessEdit:
+		return p.CanEdit
+	case accessShare:
+		return p.CanShare
+	default:
+		return false
+	}
+}
+
+type fileAccess struct {
+	FileID  uuid.UUID `db:"id"`
+	OwnerID uuid.UUID `db:"user_id"`
+	permissionFlags
+}
+
+type folderAccess struct {
+	FolderID uuid.UUID `db:"id"`
+	OwnerID  uuid.UUID `db:"user_id"`
+	permissionFlags
+}
+
+// getFileAccess combines a direct file grant with grants on any folder
+// containing the file.
+func (h *FileHandler) getFileAccess(fileID, userID string) (*fileAccess, error) {
+	var access fileAccess
+	err := h.db.Get(&access, `
+		WITH RECURSIVE ancestors AS (
+			SELECT fo.id, fo.parent_id
+			FROM folders fo
+			JOIN files f ON f.folder_id = fo.id
+			WHERE f.id = $1
+			UNION ALL
+			SELECT fo.id, fo.parent_id
+			FROM folders fo
+			JOIN ancestors a ON fo.id = a.parent_id
+		)
+		SELECT f.id, f.user_id, f.user_id = $2 AS is_owner,
+		       COALESCE(p.can_view, FALSE) OR COALESCE(fp.can_view, FALSE) AS can_view,
+		       COALESCE(p.can_edit, FALSE) OR COALESCE(fp.can_edit, FALSE) AS can_edit,
+		       COALESCE(p.can_share, FALSE) OR COALESCE(fp.can_share, FALSE) AS can_share
+		FROM files f
+		LEFT JOIN file_permissions p ON p.file_id = f.id AND p.user_id = $2
+		LEFT JOIN LATERAL (
+			SELECT bool_or(can_view) AS can_view, bool_or(can_edit) AS can_edit, bool_or(can_share) AS can_share
+			FROM folder_permissions
+			WHERE user_id = $2 AND folder_id IN (SELECT id FROM ancestors)
+		) fp ON TRUE
+		WHERE f.id = $1 AND f.deleted_at IS NULL`, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &access, nil
+}
+
+// getFolderAccess combines grants on the folder and all of its ancestors.
+func (h *FileHandler) getFolderAccess(folderID, userID string) (*folderAccess, error) {
+	var access folderAccess
+	err := h.db.Get(&access, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM folders WHERE id = $1
+			UNION ALL
+			SELECT fo.id, fo.parent_id
+			FROM folders fo
+			JOIN ancestors a ON fo.id = a.parent_id
+		)
+		SELECT fo.id, fo.user_id, fo.user_id = $2 AS is_owner,
+		       COALESCE(fp.can_view, FALSE) AS can_view,
+		       COALESCE(fp.can_edit, FALSE) AS can_edit,
+		       COALESCE(fp.can_share, FALSE) AS can_share
+		FROM folders fo
+		LEFT JOIN LATERAL (
+			SELECT bool_or(can_view) AS can_view, bool_or(can_edit) AS can_edit, bool_or(can_share) AS can_share
+			FROM folder_permissions
+			WHERE user_id = $2 AND folder_id IN (SELECT id FROM ancestors)
+		) fp ON TRUE
+		WHERE fo.id = $1`, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &access, nil
+}
+
+// authorizeFile checks the caller's access to the :file_id route parameter.
+// It writes the error response itself: 404 when the caller cannot see the
+// file at all (so its existence isn't revealed), 403 when they can see it
+// but lack the requested level.
+func (h *FileHandler) authorizeFile(c *gin.Context, level accessLevel) (*fileAccess, bool) {
+	if _, err := uuid.Parse(c.Param("file_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, false
+	}
+
+	access, err := h.getFileAccess(c.Param("file_id"), c.GetString("userID"))
+	if err != nil || !access.allows(accessView) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, false
+	}
+	if !access.allows(level) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, false
+	}
+	return access, true
+}
+
+// authorizeFolder is authorizeFile for a folder ID, which callers take from
+// the :folder_id parameter or a request body.
+func (h *FileHandler) authorizeFolder(c *gin.Context, folderID string, level accessLevel) (*folderAccess, bool) {
+	if _, err := uuid.Parse(folderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return nil, false
+	}
+
+	access, err := h.getFolderAccess(folderID, c.GetString("userID"))
+	if err != nil || !access.allows(accessView) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return nil, false
+	}
+	if !access.allows(level) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, false
+	}
+	return access, true
+}
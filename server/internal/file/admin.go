@@ -0,0 +1,210 @@
+package file
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Admin endpoints act on any file, share link or account regardless of
+// ownership; the routes check the caller's role.
+
+// nullableInt distinguishes an absent JSON field (leave unchanged) from an
+// explicit null (clear).
+type nullableInt struct {
+	Set   bool
+	Value *int64
+}
+
+func (n *nullableInt) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if bytes.Equal(data, []byte("null")) {
+		n.Value = nil
+		return nil
+	}
+	return json.Unmarshal(data, &n.Value)
+}
+
+// GetAnyFile returns a file's metadata, versions and share links for any
+// owner, trashed files included. Content is never served from here.
+func (h *FileHandler) GetAnyFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	type adminFile struct {
+		ID             uuid.UUID     `json:"id" db:"id"`
+		OwnerID        uuid.UUID     `json:"owner_id" db:"user_id"`
+		OwnerEmail     string        `json:"owner" db:"owner_email"`
+		FolderID       uuid.NullUUID `json:"folder_id" db:"folder_id"`
+		OriginalName   string        `json:"filename" db:"original_name"`
+		Size           int64         `json:"size" db:"size"`
+		MimeType       string        `json:"mime_type" db:"mime_type"`
+		Checksum       *string       `json:"checksum" db:"checksum"`
+		StorageType    string        `json:"storage_type" db:"storage_type"`
+		CurrentVersion int           `json:"current_version" db:"current_version"`
+		ScanStatus     string        `json:"scan_status" db:"scan_status"`
+		ScanSignature  *string       `json:"scan_signature" db:"scan_signature"`
+		UploadIP       *string       `json:"upload_ip" db:"upload_ip"`
+		CreatedAt      time.Time     `json:"created_at" db:"created_at"`
+		UpdatedAt      *time.Time    `json:"updated_at" db:"updated_at"`
+		DeletedAt      *time.Time    `json:"deleted_at" db:"deleted_at"`
+	}
+
+	ctx := c.Request.Context()
+	var file adminFile
+	err = h.db.GetContext(ctx, &file, `
+		SELECT f.id, f.user_id, u.email AS owner_email, f.folder_id, f.original_name, f.size,
+		       f.mime_type, f.checksum, f.storage_type, f.current_version, f.scan_status,
+		       f.scan_signature, host(f.upload_ip) AS upload_ip, f.created_at, f.updated_at,
+		       f.deleted_at
+		FROM files f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.id = $1`, fileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file"})
+		return
+	}
+
+	versions := []fileVersion{}
+	err = h.db.SelectContext(ctx, &versions, `
+		SELECT v.version, v.size, v.checksum, v.mime_type, v.storage_path, v.storage_type,
+		       v.created_by, v.created_at, v.version = f.current_version AS is_current,
+		       v.scan_status
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE v.file_id = $1
+		ORDER BY v.version DESC`, fileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get versions"})
+		return
+	}
+
+	// Every link, including revoked and expired ones
+	type adminShareLink struct {
+		shareLinkResponse
+		RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+	}
+	links := []adminShareLink{}
+	err = h.db.SelectContext(ctx, &links, `
+		SELECT token, '/share/' || token AS share_url, created_by, created_at, expires_at,
+		       password_hash IS NOT NULL AS has_password, max_downloads, download_count,
+		       revoked_at
+		FROM file_shares
+		WHERE file_id = $1
+		ORDER BY created_at DESC`, fileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get share links"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file, "versions": versions, "share_links": links})
+}
+
+// ForceRevokeShareLink revokes any share link by token, e.g. one reported
+// for abuse.
+func (h *FileHandler) ForceRevokeShareLink(c *gin.Context) {
+	token := c.Param("token")
+	result, err := h.db.Exec(`
+		UPDATE file_shares SET revoked_at = NOW()
+		WHERE token = $1 AND revoked_at IS NULL`, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	h.redisClient.Del(c.Request.Context(), "file_share:"+token)
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
+}
+
+// SetUserQuota changes a user's plan and/or per-user limit overrides. A
+// null limit drops the override so the plan's limit applies again.
+func (h *FileHandler) SetUserQuota(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req struct {
+		Plan       *string     `json:"plan"`
+		QuotaBytes nullableInt `json:"quota_bytes"`
+		QuotaFiles nullableInt `json:"quota_files"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, v := range []nullableInt{req.QuotaBytes, req.QuotaFiles} {
+		if v.Value != nil && *v.Value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quota limits must not be negative"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	if req.Plan != nil {
+		var exists bool
+		if err := h.db.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM plans WHERE name = $1)`, *req.Plan); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown plan"})
+			return
+		}
+	}
+
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE users
+		SET plan = COALESCE($1, plan),
+		    quota_bytes = CASE WHEN $2 THEN $3::bigint ELSE quota_bytes END,
+		    quota_files = CASE WHEN $4 THEN $5::integer ELSE quota_files END
+		WHERE id = $6`,
+		req.Plan, req.QuotaBytes.Set, req.QuotaBytes.Value, req.QuotaFiles.Set, req.QuotaFiles.Value, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quota"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	qt, err := loadQuota(ctx, h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"plan":    qt.Plan,
+		"bytes": gin.H{
+			"used":      qt.UsedBytes,
+			"limit":     nullableLimit(qt.MaxBytes),
+			"available": available(qt.MaxBytes, qt.UsedBytes),
+		},
+		"files": gin.H{
+			"used":      qt.UsedFiles,
+			"limit":     nullableLimit(qt.MaxFiles),
+			"available": available(qt.MaxFiles, qt.UsedFiles),
+		},
+	})
+}
@@ -0,0 +1,239 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// Stored content is addressed by its SHA-256: identical uploads share one
+// object under blobs/<aa>/<checksum>, and the blobs table counts how many
+// version rows reference it. Every file_versions row owns exactly one
+// reference; the files row mirrors its current version and owns none.
+
+// storedObject is a blob on the primary backend with one reference held
+// for the caller, to be handed to a new version row or released.
+type storedObject struct {
+	Name        string
+	StoragePath string
+	StorageType string
+	Checksum    string
+	Size        int64
+}
+
+// nullString stores an empty checksum (a legacy object) as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func blobKey(checksum string) string {
+	return path.Join("blobs", checksum[:2], checksum)
+}
+
+// hashContent computes the SHA-256 of r and returns a reader positioned at
+// the start of the same content. Seekable inputs (multipart parts, tus
+// staging files) are rewound; anything else is spooled to a temp file.
+// The returned cleanup must always be called.
+func hashContent(r io.Reader) (string, int64, io.Reader, func(), error) {
+	hasher := sha256.New()
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		n, err := io.Copy(hasher, rs)
+		if err == nil {
+			_, err = rs.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return "", 0, nil, func() {}, err
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), n, rs, func() {}, nil
+	}
+
+	spool, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return "", 0, nil, func() {}, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	n, err := io.Copy(io.MultiWriter(spool, hasher), r)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return "", 0, nil, func() {}, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), n, spool, cleanup, nil
+}
+
+// putObject hashes r and takes a reference on the matching blob, writing
+// it to the primary backend only when that content isn't stored yet.
+func (h *FileHandler) putObject(ctx context.Context, originalName, mimeType string, size int64, r io.Reader) (*storedObject, error) {
+	store := h.storage.Primary()
+
+	checksum, n, content, cleanup, err := hashContent(r)
+	defer cleanup()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if n != size {
+		return nil, fmt.Errorf("upload size mismatch: expected %d bytes, got %d", size, n)
+	}
+
+	obj := &storedObject{
+		Name:        uuid.New().String() + filepath.Ext(originalName),
+		StorageType: store.Type(),
+		Checksum:    checksum,
+		Size:        size,
+	}
+
+	// Take the reference first, creating the blob row if this content is
+	// new. The row lock is held until the object is written, so a
+	// concurrent release of the same content waits for it and a concurrent
+	// upload of it waits to share it.
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record blob: %w", err)
+	}
+	defer tx.Rollback()
+
+	var blob struct {
+		StoragePath string `db:"storage_path"`
+		RefCount    int    `db:"ref_count"`
+	}
+	err = tx.GetContext(ctx, &blob, `
+		INSERT INTO blobs (storage_type, checksum, storage_path, size, ref_count)
+		VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT (storage_type, checksum) DO UPDATE SET ref_count = blobs.ref_count + 1
+		RETURNING storage_path, ref_count`,
+		obj.StorageType, checksum, blobKey(checksum), size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record blob: %w", err)
+	}
+	obj.StoragePath = blob.StoragePath
+
+	// Only the first reference writes the content
+	if blob.RefCount == 1 {
+		if err := store.Put(ctx, obj.StoragePath, content, size, mimeType); err != nil {
+			return nil, fmt.Errorf("failed to write to storage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to record blob: %w", err)
+	}
+	return obj, nil
+}
+
+// retainObject takes another reference on an existing blob, e.g. when a
+// restored version shares an older version's content. Legacy objects
+// without a blob row are reference-counted by their rows instead.
+func (h *FileHandler) retainObject(ctx context.Context, storageType, storagePath string) error {
+	_, err := h.db.ExecContext(ctx, `
+		UPDATE blobs SET ref_count = ref_count + 1
+		WHERE storage_type = $1 AND storage_path = $2`, storageType, storagePath)
+	return err
+}
+
+// releaseObject drops one reference to a stored object and deletes the
+// object once nothing references it.
+func (h *FileHandler) releaseObject(ctx context.Context, storageType, storagePath string) error {
+	store, err := h.storage.Backend(storageType)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var refs int
+	err = tx.GetContext(ctx, &refs, `
+		UPDATE blobs SET ref_count = ref_count - 1
+		WHERE storage_type = $1 AND storage_path = $2 AND ref_count > 0
+		RETURNING ref_count`, storageType, storagePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return h.releaseLegacyObject(ctx, storageType, storagePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if refs == 0 {
+		h.releaseThumbnails(ctx, storageType, storagePath)
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM blobs WHERE storage_type = $1 AND storage_path = $2`, storageType, storagePath); err != nil {
+			return err
+		}
+		// Delete while the row lock is held so a concurrent upload of the
+		// same content waits and then writes it afresh. A failed delete
+		// only leaves an orphan that a later upload overwrites.
+		if err := store.Delete(ctx, storagePath); err != nil {
+			log.Printf("Failed to delete blob %s: %v", storagePath, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// releaseLegacyObject deletes a pre-dedup object once neither a file nor
+// any of its versions still points at it.
+func (h *FileHandler) releaseLegacyObject(ctx context.Context, storageType, storagePath string) error {
+	var refs int
+	err := h.db.GetContext(ctx, &refs, `
+		SELECT (SELECT COUNT(*) FROM files WHERE storage_type = $1 AND storage_path = $2)
+		     + (SELECT COUNT(*) FROM file_versions WHERE storage_type = $1 AND storage_path = $2)`,
+		storageType, storagePath)
+	if err != nil || refs > 0 {
+		return err
+	}
+
+	store, err := h.storage.Backend(storageType)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, storagePath)
+}
+
+// verifyingReader hashes content as it is streamed and fails the final
+// read if it doesn't match the recorded checksum, so a corrupted object
+// aborts the response instead of completing silently.
+type verifyingReader struct {
+	r        io.Reader
+	hasher   hash.Hash
+	checksum string
+	key      string
+}
+
+func newVerifyingReader(r io.Reader, checksum, key string) io.Reader {
+	if checksum == "" {
+		return r
+	}
+	return &verifyingReader{r: r, hasher: sha256.New(), checksum: checksum, key: key}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hasher.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if sum := hex.EncodeToString(v.hasher.Sum(nil)); sum != v.checksum {
+			log.Printf("Checksum mismatch for %s: expected %s, got %s", v.key, v.checksum, sum)
+			return n, fmt.Errorf("checksum mismatch for %s", v.key)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,489 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Folders form a per-owner logical tree. Moving or renaming only touches
+// rows; stored objects stay under their content-addressed blobs/ keys.
+
+// rootFolder is the :folder_id (or body value) that addresses the caller's
+// top level.
+const rootFolder = "root"
+
+type folderEntry struct {
+	ID        uuid.UUID     `json:"id" db:"id"`
+	ParentID  uuid.NullUUID `json:"parent_id" db:"parent_id"`
+	Name      string        `json:"name" db:"name"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+type fileEntry struct {
+	ID           uuid.UUID     `json:"id" db:"id"`
+	FolderID     uuid.NullUUID `json:"folder_id" db:"folder_id"`
+	OriginalName string        `json:"filename" db:"original_name"`
+	Size         int64         `json:"size" db:"size"`
+	MimeType     string        `json:"mime_type" db:"mime_type"`
+	CreatedAt    time.Time     `json:"created_at" db:"created_at"`
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 255 && !strings.Contains(name, "/")
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (h *FileHandler) getFolder(folderID uuid.UUID) (*folderEntry, error) {
+	var folder folderEntry
+	err := h.db.Get(&folder, `
+		SELECT id, parent_id, name, created_at, updated_at
+		FROM folders WHERE id = $1`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// resolveTargetFolder authorizes a destination folder given in a request.
+// "root" (or empty) means the top level of ownerID's tree; any other folder
+// must be editable by the caller and owned by ownerID when ownerID is set.
+func (h *FileHandler) resolveTargetFolder(c *gin.Context, folderID string, ownerID uuid.UUID) (uuid.NullUUID, uuid.UUID, bool) {
+	if folderID == "" || folderID == rootFolder {
+		return uuid.NullUUID{}, ownerID, true
+	}
+
+	access, ok := h.authorizeFolder(c, folderID, accessEdit)
+	if !ok {
+		return uuid.NullUUID{}, uuid.Nil, false
+	}
+	if ownerID != uuid.Nil && access.OwnerID != ownerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot move items into another user's folder"})
+		return uuid.NullUUID{}, uuid.Nil, false
+	}
+	return uuid.NullUUID{UUID: access.FolderID, Valid: true}, access.OwnerID, true
+}
+
+func (h *FileHandler) CreateFolder(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("userID").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name" binding:"required"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder name"})
+		return
+	}
+
+	// New folders belong to the owner of the tree they are created in
+	parentID, ownerID, ok := h.resolveTargetFolder(c, req.ParentID, uuid.Nil)
+	if !ok {
+		return
+	}
+	if !parentID.Valid {
+		ownerID = userID
+	}
+
+	var folder folderEntry
+	err = h.db.Get(&folder, `
+		INSERT INTO folders (user_id, parent_id, name)
+		VALUES ($1, $2, $3)
+		RETURNING id, parent_id, name, created_at, updated_at`, ownerID, parentID, req.Name)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A folder with that name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"folder": folder})
+}
+
+// ListFolderChildren lists the subfolders and files directly inside a
+// folder, or the caller's top level for "root".
+func (h *FileHandler) ListFolderChildren(c *gin.Context) {
+	folders := []folderEntry{}
+	files := []fileEntry{}
+
+	if c.Param("folder_id") == rootFolder {
+		userID := c.GetString("userID")
+		err := h.db.Select(&folders, `
+			SELECT id, parent_id, name, created_at, updated_at
+			FROM folders
+			WHERE user_id = $1 AND parent_id IS NULL
+			ORDER BY name`, userID)
+		if err == nil {
+			err = h.db.Select(&files, `
+				SELECT id, folder_id, original_name, size, mime_type, created_at
+				FROM files
+				WHERE user_id = $1 AND folder_id IS NULL AND deleted_at IS NULL
+				ORDER BY original_name`, userID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list folder"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"folder": nil, "folders": folders, "files": files})
+		return
+	}
+
+	access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessView)
+	if !ok {
+		return
+	}
+
+	folder, err := h.getFolder(access.FolderID)
+	if err == nil {
+		err = h.db.Select(&folders, `
+			SELECT id, parent_id, name, created_at, updated_at
+			FROM folders
+			WHERE parent_id = $1
+			ORDER BY name`, access.FolderID)
+	}
+	if err == nil {
+		err = h.db.Select(&files, `
+			SELECT id, folder_id, original_name, size, mime_type, created_at
+			FROM files
+			WHERE folder_id = $1 AND deleted_at IS NULL
+			ORDER BY original_name`, access.FolderID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folder": folder, "folders": folders, "files": files})
+}
+
+// UpdateFolder renames a folder and/or moves it under a new parent.
+func (h *FileHandler) UpdateFolder(c *gin.Context) {
+	var req struct {
+		Name     *string `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level := accessEdit
+	if req.ParentID != nil {
+		level = accessOwner
+	}
+	access, ok := h.authorizeFolder(c, c.Param("folder_id"), level)
+	if !ok {
+		return
+	}
+
+	folder, err := h.getFolder(access.FolderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+
+	// 1. Rename
+	if req.Name != nil {
+		if !validName(*req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder name"})
+			return
+		}
+		folder.Name = *req.Name
+	}
+
+	// 2. Move, refusing to place a folder inside its own subtree
+	if req.ParentID != nil {
+		parentID, _, ok := h.resolveTargetFolder(c, *req.ParentID, access.OwnerID)
+		if !ok {
+			return
+		}
+		if parentID.Valid {
+			var cycle bool
+			err := h.db.Get(&cycle, `
+				WITH RECURSIVE ancestors AS (
+					SELECT id, parent_id FROM folders WHERE id = $1
+					UNION ALL
+					SELECT fo.id, fo.parent_id
+					FROM folders fo
+					JOIN ancestors a ON fo.id = a.parent_id
+				)
+				SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`, parentID.UUID, access.FolderID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move folder"})
+				return
+			}
+			if cycle {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot move a folder into itself"})
+				return
+			}
+		}
+		folder.ParentID = parentID
+	}
+
+	err = h.db.Get(folder, `
+		UPDATE folders SET name = $1, parent_id = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING id, parent_id, name, created_at, updated_at`, folder.Name, folder.ParentID, folder.ID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A folder with that name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folder": folder})
+}
+
+// DeleteFolder removes an empty folder. With ?recursive=true the files
+// below it are moved to the trash and every subfolder is removed.
+func (h *FileHandler) DeleteFolder(c *gin.Context) {
+	access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessOwner)
+	if !ok {
+		return
+	}
+
+	var fileIDs []string
+	err := h.db.Select(&fileIDs, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION ALL
+			SELECT fo.id FROM folders fo JOIN subtree s ON fo.parent_id = s.id
+		)
+		SELECT id FROM files
+		WHERE folder_id IN (SELECT id FROM subtree) AND deleted_at IS NULL`, access.FolderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+
+	if c.Query("recursive") != "true" {
+		var children int
+		err := h.db.Get(&children, `SELECT COUNT(*) FROM folders WHERE parent_id = $1`, access.FolderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+			return
+		}
+		if children > 0 || len(fileIDs) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Folder is not empty"})
+			return
+		}
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+	defer tx.Rollback()
+
+	if len(fileIDs) > 0 {
+		_, err = tx.Exec(`
+			UPDATE files SET deleted_at = NOW()
+			WHERE id = ANY($1)`, pq.Array(fileIDs))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+			return
+		}
+	}
+
+	// Subfolders cascade; files keep their row with folder_id cleared
+	if _, err := tx.Exec(`DELETE FROM folders WHERE id = $1`, access.FolderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	h.invalidateFileCache(ctx, access.OwnerID.String())
+	for _, fileID := range fileIDs {
+		h.invalidateShareCache(ctx, fileID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Folder deleted successfully",
+		"trashed_files": len(fileIDs),
+	})
+}
+
+// UpdateFile renames a file and/or moves it to another folder of the same
+// owner.
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessEdit)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		FolderID *string `json:"folder_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var file fileEntry
+	err := h.db.Get(&file, `
+		SELECT id, folder_id, original_name, size, mime_type, created_at
+		FROM files WHERE id = $1`, access.FileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if req.Name != nil {
+		if !validName(*req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name"})
+			return
+		}
+		file.OriginalName = *req.Name
+	}
+
+	if req.FolderID != nil {
+		folderID, _, ok := h.resolveTargetFolder(c, *req.FolderID, access.OwnerID)
+		if !ok {
+			return
+		}
+		file.FolderID = folderID
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE files SET original_name = $1, folder_id = $2, updated_at = NOW()
+		WHERE id = $3`, file.OriginalName, file.FolderID, file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file"})
+		return
+	}
+
+	h.invalidateFileCache(c.Request.Context(), access.OwnerID.String())
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
+// GetFileByPath resolves /folder/sub/name.ext within the caller's own
+// tree. When several files share a name the most recent one wins.
+func (h *FileHandler) GetFileByPath(c *gin.Context) {
+	userID := c.GetString("userID")
+	segments := strings.Split(strings.Trim(c.Query("path"), "/"), "/")
+	if len(segments) == 0 || segments[len(segments)-1] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	// 1. Walk the folders
+	var parentID uuid.NullUUID
+	for _, name := range segments[:len(segments)-1] {
+		var folderID uuid.UUID
+		err := h.db.Get(&folderID, `
+			SELECT id FROM folders
+			WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3`,
+			userID, parentID, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve path"})
+			return
+		}
+		parentID = uuid.NullUUID{UUID: folderID, Valid: true}
+	}
+
+	// 2. Find the file in the last folder
+	var file fileEntry
+	err := h.db.Get(&file, `
+		SELECT id, folder_id, original_name, size, mime_type, created_at
+		FROM files
+		WHERE user_id = $1 AND folder_id IS NOT DISTINCT FROM $2 AND original_name = $3
+		  AND deleted_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1`, userID, parentID, segments[len(segments)-1])
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve path"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
+// GetSharedFolders lists folders other users have granted the caller
+// access to.
+func (h *FileHandler) GetSharedFolders(c *gin.Context) {
+	type sharedFolderResponse struct {
+		folderEntry
+		OwnerEmail string `json:"owner" db:"owner_email"`
+		CanView    bool   `json:"can_view" db:"can_view"`
+		CanEdit    bool   `json:"can_edit" db:"can_edit"`
+		CanShare   bool   `json:"can_share" db:"can_share"`
+	}
+
+	folders := []sharedFolderResponse{}
+	err := h.db.Select(&folders, `
+		SELECT fo.id, fo.parent_id, fo.name, fo.created_at, fo.updated_at,
+		       u.email AS owner_email, p.can_view, p.can_edit, p.can_share
+		FROM folder_permissions p
+		JOIN folders fo ON fo.id = p.folder_id
+		JOIN users u ON u.id = fo.user_id
+		WHERE p.user_id = $1 AND (p.can_view OR p.can_edit)
+		ORDER BY p.granted_at DESC`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shared folders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+func (h *FileHandler) ListFolderPermissions(c *gin.Context) {
+	if access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessOwner); ok {
+		h.listPermissions(c, folderPermissions, access.FolderID)
+	}
+}
+
+func (h *FileHandler) GrantFolderPermission(c *gin.Context) {
+	if access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessOwner); ok {
+		h.grantPermission(c, folderPermissions, access.FolderID, access.OwnerID)
+	}
+}
+
+func (h *FileHandler) UpdateFolderPermission(c *gin.Context) {
+	if access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessOwner); ok {
+		h.updatePermission(c, folderPermissions, access.FolderID)
+	}
+}
+
+func (h *FileHandler) RevokeFolderPermission(c *gin.Context) {
+	if access, ok := h.authorizeFolder(c, c.Param("folder_id"), accessOwner); ok {
+		h.revokePermission(c, folderPermissions, access.FolderID)
+	}
+}
@@ -0,0 +1,311 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	fileListTTL     = 5 * time.Minute
+)
+
+// sortColumns maps the ?sort= values to files columns.
+var sortColumns = map[string]string{
+	"created_at": "created_at",
+	"name":       "original_name",
+	"size":       "size",
+}
+
+// listQuery is a validated GetUserFiles request. Its canonical form keys
+// the cache, so equivalent query strings share an entry.
+type listQuery struct {
+	Limit          int
+	Sort           string
+	Order          string
+	Cursor         *listCursor
+	MimeType       string
+	NamePrefix     string
+	MinSize        *int64
+	MaxSize        *int64
+	UploadedAfter  *time.Time
+	UploadedBefore *time.Time
+}
+
+// listCursor marks the last row of the previous page. It carries the sort
+// it was issued for so it cannot be replayed against another ordering.
+type listCursor struct {
+	Sort  string    `json:"s"`
+	Order string    `json:"o"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func (cur *listCursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur listCursor
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// valid reports whether the cursor's value parses as its sort column's
+// type, so a tampered cursor is refused before it reaches the query.
+func (cur *listCursor) valid() bool {
+	switch cur.Sort {
+	case "created_at":
+		_, err := time.Parse(time.RFC3339Nano, cur.Value)
+		return err == nil
+	case "size":
+		_, err := strconv.ParseInt(cur.Value, 10, 64)
+		return err == nil
+	case "name":
+		return utf8.ValidString(cur.Value) && !strings.ContainsRune(cur.Value, 0)
+	}
+	return false
+}
+
+func parseListQuery(c *gin.Context) (*listQuery, error) {
+	q := &listQuery{
+		Limit:      defaultPageSize,
+		Sort:       c.DefaultQuery("sort", "created_at"),
+		Order:      strings.ToLower(c.DefaultQuery("order", "desc")),
+		MimeType:   strings.TrimSuffix(c.Query("mime_type"), "*"),
+		NamePrefix: c.Query("name_prefix"),
+	}
+
+	if _, ok := sortColumns[q.Sort]; !ok {
+		return nil, fmt.Errorf("sort must be one of created_at, name, size")
+	}
+	if q.Order != "asc" && q.Order != "desc" {
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = limit
+	}
+
+	for name, dst := range map[string]**int64{"min_size": &q.MinSize, "max_size": &q.MaxSize} {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*dst = &n
+		}
+	}
+
+	for name, dst := range map[string]**time.Time{"uploaded_after": &q.UploadedAfter, "uploaded_before": &q.UploadedBefore} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil || cur.Sort != q.Sort || cur.Order != q.Order || !cur.valid() {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		q.Cursor = cur
+	}
+
+	return q, nil
+}
+
+// cacheKey hashes the canonical query so every parameter combination gets
+// its own entry under the user's current cache generation.
+func (q *listQuery) cacheKey(userID string, generation int64) string {
+	canonical, _ := json.Marshal(q)
+	sum := sha256.Sum256(canonical)
+	return fmt.Sprintf("user_files:%s:%d:%s", userID, generation, hex.EncodeToString(sum[:16]))
+}
+
+// sql builds the listing query using keyset pagination on (sort, id).
+func (q *listQuery) sql(userID uuid.UUID) (string, []interface{}) {
+	column := sortColumns[q.Sort]
+	args := []interface{}{userID}
+	where := []string{"user_id = $1", "deleted_at IS NULL"}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.MimeType != "" {
+		if strings.HasSuffix(q.MimeType, "/") {
+			where = append(where, "mime_type LIKE "+arg(escapeLike(q.MimeType)+"%"))
+		} else {
+			where = append(where, "mime_type = "+arg(q.MimeType))
+		}
+	}
+	if q.NamePrefix != "" {
+		where = append(where, "original_name LIKE "+arg(escapeLike(q.NamePrefix)+"%"))
+	}
+	if q.MinSize != nil {
+		where = append(where, "size >= "+arg(*q.MinSize))
+	}
+	if q.MaxSize != nil {
+		where = append(where, "size <= "+arg(*q.MaxSize))
+	}
+	if q.UploadedAfter != nil {
+		where = append(where, "created_at >= "+arg(*q.UploadedAfter))
+	}
+	if q.UploadedBefore != nil {
+		where = append(where, "created_at < "+arg(*q.UploadedBefore))
+	}
+
+	cmp, dir := "<", "DESC"
+	if q.Order == "asc" {
+		cmp, dir = ">", "ASC"
+	}
+	if q.Cursor != nil {
+		cast := map[string]string{"created_at": "timestamptz", "name": "text", "size": "bigint"}[q.Sort]
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s::uuid)",
+			column, cmp, arg(q.Cursor.Value), cast, arg(q.Cursor.ID)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, name, original_name, size, mime_type, checksum, scan_status, folder_id, created_at
+		FROM files
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT %s`, strings.Join(where, " AND "), column, dir, dir, arg(q.Limit+1))
+	return query, args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// fileListGeneration returns the user's current listing cache generation.
+// Bumping it (see invalidateFileCache) orphans every cached page at once.
+func (h *FileHandler) fileListGeneration(ctx context.Context, userID string) int64 {
+	gen, err := h.redisClient.Get(ctx, "user_files_gen:"+userID).Int64()
+	if err != nil {
+		return 0
+	}
+	return gen
+}
+
+// GetUserFiles lists the caller's files. The body stays a JSON array;
+// X-Next-Cursor carries the cursor for the next page when there is one.
+//
+// Query parameters: limit, cursor, sort (created_at|name|size),
+// order (asc|desc), mime_type ("image/png" or "image/*"), name_prefix,
+// min_size, max_size, uploaded_after, uploaded_before (RFC3339).
+func (h *FileHandler) GetUserFiles(c *gin.Context) {
+	// 1. Get user ID from auth middleware with proper UUID parsing
+	userIDString := c.MustGet("userID").(string)
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	// 2. Validate query parameters
+	q, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	// 3. Serve from cache when this exact page is cached
+	type cachedPage struct {
+		Files      json.RawMessage `json:"files"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	cacheKey := q.cacheKey(userID.String(), h.fileListGeneration(ctx, userID.String()))
+	if val, err := h.redisClient.Get(ctx, cacheKey).Bytes(); err == nil {
+		var page cachedPage
+		if json.Unmarshal(val, &page) == nil {
+			if page.NextCursor != "" {
+				c.Header("X-Next-Cursor", page.NextCursor)
+			}
+			c.Data(http.StatusOK, "application/json", page.Files)
+			return
+		}
+	}
+
+	// 4. Define response structure including all needed fields from Upload
+	type fileResponse struct {
+		ID           uuid.UUID     `json:"id" db:"id"`
+		Name         string        `json:"name" db:"name"`
+		OriginalName string        `json:"filename" db:"original_name"`
+		Size         int64         `json:"size" db:"size"`
+		MimeType     string        `json:"mime_type" db:"mime_type"`
+		Checksum     *string       `json:"checksum" db:"checksum"`
+		ScanStatus   string        `json:"scan_status" db:"scan_status"`
+		FolderID     uuid.NullUUID `json:"folder_id" db:"folder_id"`
+		CreatedAt    time.Time     `json:"created_at" db:"created_at"`
+	}
+
+	// 5. Fetch one extra row to know whether another page follows
+	files := []fileResponse{}
+	query, args := q.sql(userID)
+	if err := h.db.Select(&files, query, args...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get files"})
+		return
+	}
+
+	var nextCursor string
+	if len(files) > q.Limit {
+		files = files[:q.Limit]
+		last := files[len(files)-1]
+		cur := &listCursor{Sort: q.Sort, Order: q.Order, ID: last.ID}
+		switch q.Sort {
+		case "created_at":
+			cur.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		case "name":
+			cur.Value = last.OriginalName
+		case "size":
+			cur.Value = strconv.FormatInt(last.Size, 10)
+		}
+		nextCursor = cur.encode()
+	}
+
+	jsonData, err := json.Marshal(files)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal files"})
+		return
+	}
+
+	if page, err := json.Marshal(cachedPage{Files: jsonData, NextCursor: nextCursor}); err == nil {
+		h.redisClient.Set(ctx, cacheKey, page, fileListTTL)
+	}
+	if nextCursor != "" {
+		c.Header("X-Next-Cursor", nextCursor)
+	}
+	c.Data(http.StatusOK, "application/json", jsonData)
+}
@@ -0,0 +1,56 @@
+package file
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cur := &listCursor{Sort: "name", Order: "asc", Value: "report.pdf", ID: uuid.New()}
+	got, err := decodeCursor(cur.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *cur {
+		t.Errorf("decodeCursor(encode()) = %+v, want %+v", got, cur)
+	}
+}
+
+func TestParseListQueryCursor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	cursor := func(sort, order, value string) string {
+		return (&listCursor{Sort: sort, Order: order, Value: value, ID: id}).encode()
+	}
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		{"created_at", "cursor=" + cursor("created_at", "desc", time.Now().Format(time.RFC3339Nano)), true},
+		{"size", "sort=size&cursor=" + cursor("size", "desc", "1024"), true},
+		{"name", "sort=name&order=asc&cursor=" + cursor("name", "asc", "a.txt"), true},
+		{"not base64", "cursor=!!!", false},
+		{"not json", "cursor=bm90IGpzb24", false},
+		{"other sort", "sort=size&cursor=" + cursor("name", "desc", "a.txt"), false},
+		{"other order", "cursor=" + cursor("created_at", "asc", time.Now().Format(time.RFC3339Nano)), false},
+		{"bad timestamp", "cursor=" + cursor("created_at", "desc", "yesterday"), false},
+		{"bad size", "sort=size&cursor=" + cursor("size", "desc", "1e3"), false},
+		{"NUL in name", "sort=name&cursor=" + cursor("name", "desc", "a\x00b"), false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/files?"+tt.query, nil)
+		_, err := parseListQuery(c)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && (err == nil || err.Error() != "invalid cursor") {
+			t.Errorf("%s: err = %v, want invalid cursor", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// permissionTable identifies where grants for one kind of resource live.
+// Files and folders share the same can_view/can_edit/can_share semantics.
+type permissionTable struct {
+	table  string
+	column string
+}
+
+var (
+	filePermissions   = permissionTable{table: "file_permissions", column: "file_id"}
+	folderPermissions = permissionTable{table: "folder_permissions", column: "folder_id"}
+)
+
+type permissionRequest struct {
+	CanView  *bool `json:"can_view"`
+	CanEdit  *bool `json:"can_edit"`
+	CanShare *bool `json:"can_share"`
+}
+
+type grantPermissionRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	permissionRequest
+}
+
+type permissionResponse struct {
+	UserID    uuid.UUID     `json:"user_id" db:"user_id"`
+	Email     string        `json:"email" db:"email"`
+	CanView   bool          `json:"can_view" db:"can_view"`
+	CanEdit   bool          `json:"can_edit" db:"can_edit"`
+	CanShare  bool          `json:"can_share" db:"can_share"`
+	GrantedBy uuid.NullUUID `json:"granted_by" db:"granted_by"`
+	GrantedAt time.Time     `json:"granted_at" db:"granted_at"`
+}
+
+func boolOr(v *bool, def bool) bool {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func (h *FileHandler) getPermission(t permissionTable, resourceID uuid.UUID, userID string) (*permissionResponse, error) {
+	var perm permissionResponse
+	err := h.db.Get(&perm, fmt.Sprintf(`
+		SELECT p.user_id, u.email, p.can_view, p.can_edit, p.can_share, p.granted_by, p.granted_at
+		FROM %s p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.%s = $1 AND p.user_id = $2`, t.table, t.column), resourceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &perm, nil
+}
+
+func (h *FileHandler) listPermissions(c *gin.Context, t permissionTable, resourceID uuid.UUID) {
+	perms := []permissionResponse{}
+	err := h.db.Select(&perms, fmt.Sprintf(`
+		SELECT p.user_id, u.email, p.can_view, p.can_edit, p.can_share, p.granted_by, p.granted_at
+		FROM %s p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.%s = $1
+		ORDER BY p.granted_at`, t.table, t.column), resourceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": perms})
+}
+
+// grantPermission gives another registered user access to a resource.
+// Granting to a user who already has access replaces their permissions.
+func (h *FileHandler) grantPermission(c *gin.Context, t permissionTable, resourceID, ownerID uuid.UUID) {
+	var req grantPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 1. Resolve the grantee
+	var granteeID uuid.UUID
+	err := h.db.Get(&granteeID, `SELECT id FROM users WHERE email = $1`, req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if granteeID == ownerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot grant permissions to the owner"})
+		return
+	}
+
+	// 2. Upsert the permission row
+	_, err = h.db.Exec(fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, user_id, can_view, can_edit, can_share, granted_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (%[2]s, user_id) DO UPDATE
+		SET can_view = EXCLUDED.can_view, can_edit = EXCLUDED.can_edit,
+		    can_share = EXCLUDED.can_share, granted_by = EXCLUDED.granted_by,
+		    granted_at = CURRENT_TIMESTAMP`, t.table, t.column),
+		resourceID, granteeID,
+		boolOr(req.CanView, true), boolOr(req.CanEdit, false), boolOr(req.CanShare, false),
+		c.GetString("userID"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant permission"})
+		return
+	}
+
+	perm, err := h.getPermission(t, resourceID, granteeID.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permission": perm})
+}
+
+// updatePermission changes only the flags present in the request body for
+// the :user_id route parameter.
+func (h *FileHandler) updatePermission(c *gin.Context, t permissionTable, resourceID uuid.UUID) {
+	if _, err := uuid.Parse(c.Param("user_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	var req permissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.db.Exec(fmt.Sprintf(`
+		UPDATE %s
+		SET can_view = COALESCE($1, can_view),
+		    can_edit = COALESCE($2, can_edit),
+		    can_share = COALESCE($3, can_share)
+		WHERE %s = $4 AND user_id = $5`, t.table, t.column),
+		req.CanView, req.CanEdit, req.CanShare, resourceID, c.Param("user_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update permission"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	perm, err := h.getPermission(t, resourceID, c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permission": perm})
+}
+
+func (h *FileHandler) revokePermission(c *gin.Context, t permissionTable, resourceID uuid.UUID) {
+	if _, err := uuid.Parse(c.Param("user_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	result, err := h.db.Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE %s = $1 AND user_id = $2`, t.table, t.column),
+		resourceID, c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke permission"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission revoked successfully"})
+}
+
+func (h *FileHandler) ListPermissions(c *gin.Context) {
+	if access, ok := h.authorizeFile(c, accessOwner); ok {
+		h.listPermissions(c, filePermissions, access.FileID)
+	}
+}
+
+func (h *FileHandler) GrantPermission(c *gin.Context) {
+	if access, ok := h.authorizeFile(c, accessOwner); ok {
+		h.grantPermission(c, filePermissions, access.FileID, access.OwnerID)
+	}
+}
+
+func (h *FileHandler) UpdatePermission(c *gin.Context) {
+	if access, ok := h.authorizeFile(c, accessOwner); ok {
+		h.updatePermission(c, filePermissions, access.FileID)
+	}
+}
+
+func (h *FileHandler) RevokePermission(c *gin.Context) {
+	if access, ok := h.authorizeFile(c, accessOwner); ok {
+		h.revokePermission(c, filePermissions, access.FileID)
+	}
+}
+
+// GetSharedWithMe lists files other users have granted the caller access to.
+func (h *FileHandler) GetSharedWithMe(c *gin.Context) {
+	type sharedFileResponse struct {
+		ID           uuid.UUID `json:"id" db:"id"`
+		OwnerEmail   string    `json:"owner" db:"owner_email"`
+		OriginalName string    `json:"filename" db:"original_name"`
+		Size         int64     `json:"size" db:"size"`
+		MimeType     string    `json:"mime_type" db:"mime_type"`
+		CanView      bool      `json:"can_view" db:"can_view"`
+		CanEdit      bool      `json:"can_edit" db:"can_edit"`
+		CanShare     bool      `json:"can_share" db:"can_share"`
+		GrantedAt    time.Time `json:"granted_at" db:"granted_at"`
+	}
+
+	files := []sharedFileResponse{}
+	err := h.db.Select(&files, `
+		SELECT f.id, u.email AS owner_email, f.original_name, f.size, f.mime_type,
+		       p.can_view, p.can_edit, p.can_share, p.granted_at
+		FROM file_permissions p
+		JOIN files f ON f.id = p.file_id
+		JOIN users u ON u.id = f.user_id
+		WHERE p.user_id = $1 AND (p.can_view OR p.can_edit) AND f.deleted_at IS NULL
+		ORDER BY p.granted_at DESC`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shared files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
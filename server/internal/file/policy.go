@@ -0,0 +1,55 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/YogendrasinghRathod/server/internal/contentpolicy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// uploadRules returns the content policy for a user's role.
+func (h *FileHandler) uploadRules(ctx context.Context, userID uuid.UUID) (contentpolicy.Rules, error) {
+	var role string
+	if err := h.db.GetContext(ctx, &role, `SELECT role FROM users WHERE id = $1`, userID); err != nil {
+		return contentpolicy.Rules{}, err
+	}
+	return h.policy.For(role), nil
+}
+
+// inspectUpload detects the MIME type of an upload from its leading bytes
+// and checks it against the uploader's content policy. r is rewound for
+// the caller. Policy violations are *contentpolicy.Rejection errors.
+func (h *FileHandler) inspectUpload(ctx context.Context, uploaderID uuid.UUID, name string, r io.ReadSeeker) (string, error) {
+	head := make([]byte, contentpolicy.SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	mimeType := contentpolicy.Detect(head[:n], name)
+	rules, err := h.uploadRules(ctx, uploaderID)
+	if err != nil {
+		return "", err
+	}
+	if err := rules.Check(mimeType, name); err != nil {
+		return "", err
+	}
+	return mimeType, nil
+}
+
+// rejectedUpload writes a 415 when err is a policy rejection.
+func rejectedUpload(c *gin.Context, err error) bool {
+	var rejection *contentpolicy.Rejection
+	if !errors.As(err, &rejection) {
+		return false
+	}
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": rejection.Error()})
+	return true
+}
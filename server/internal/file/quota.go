@@ -0,0 +1,210 @@
+package file
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Usage is charged to the owner of each file: every version's size counts
+// (content shared through deduplication included) and trashed files count
+// until they are purged.
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+type quota struct {
+	Plan      string        `db:"plan"`
+	MaxBytes  sql.NullInt64 `db:"max_bytes"`
+	MaxFiles  sql.NullInt64 `db:"max_files"`
+	UsedBytes int64         `db:"used_bytes"`
+	UsedFiles int64         `db:"used_files"`
+}
+
+// allows reports whether the account can take addBytes and addFiles more.
+func (q *quota) allows(addBytes, addFiles int64) bool {
+	if q.MaxBytes.Valid && q.UsedBytes+addBytes > q.MaxBytes.Int64 {
+		return false
+	}
+	if q.MaxFiles.Valid && q.UsedFiles+addFiles > q.MaxFiles.Int64 {
+		return false
+	}
+	return true
+}
+
+func available(limit sql.NullInt64, used int64) interface{} {
+	if !limit.Valid {
+		return nil
+	}
+	if used > limit.Int64 {
+		return int64(0)
+	}
+	return limit.Int64 - used
+}
+
+func nullableLimit(limit sql.NullInt64) interface{} {
+	if !limit.Valid {
+		return nil
+	}
+	return limit.Int64
+}
+
+// loadQuota reads a user's limits (per-user overrides, then plan) and
+// current usage. Inside a transaction it sees the caller's own inserts.
+func loadQuota(ctx context.Context, q sqlx.QueryerContext, userID uuid.UUID) (*quota, error) {
+	var qt quota
+	err := sqlx.GetContext(ctx, q, &qt, `
+		SELECT u.plan,
+		       COALESCE(u.quota_bytes, p.max_bytes) AS max_bytes,
+		       COALESCE(u.quota_files, p.max_files) AS max_files,
+		       (SELECT COALESCE(SUM(v.size), 0)
+		        FROM file_versions v JOIN files f ON f.id = v.file_id
+		        WHERE f.user_id = u.id) AS used_bytes,
+		       (SELECT COUNT(*) FROM files WHERE user_id = u.id) AS used_files
+		FROM users u
+		LEFT JOIN plans p ON p.name = u.plan
+		WHERE u.id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &qt, nil
+}
+
+// checkQuota is the early check made before any content is written.
+func (h *FileHandler) checkQuota(ctx context.Context, userID uuid.UUID, addBytes, addFiles int64) error {
+	qt, err := loadQuota(ctx, h.db, userID)
+	if err != nil {
+		return err
+	}
+	if !qt.allows(addBytes, addFiles) {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// enforceQuota is the authoritative check, made inside the transaction
+// that records new content after locking the owner's row, so concurrent
+// uploads can't both slip under the limit.
+func enforceQuota(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) error {
+	qt, err := loadQuota(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if !qt.allows(0, 0) {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func lockUser(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID)
+	return err
+}
+
+// quotaExceeded writes a 507 when err is a quota rejection.
+func quotaExceeded(c *gin.Context, err error) bool {
+	if !errors.Is(err, errQuotaExceeded) {
+		return false
+	}
+	c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Storage quota exceeded"})
+	return true
+}
+
+// GetUsage reports the caller's storage use against their limits, broken
+// down by MIME category, with the share held by trash and old versions.
+func (h *FileHandler) GetUsage(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("userID").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	qt, err := loadQuota(ctx, h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	type categoryUsage struct {
+		Category string `json:"category" db:"category"`
+		Bytes    int64  `json:"bytes" db:"bytes"`
+		Files    int64  `json:"files" db:"files"`
+	}
+	categories := []categoryUsage{}
+	err = h.db.SelectContext(ctx, &categories, `
+		SELECT category, SUM(bytes) AS bytes, COUNT(*) AS files
+		FROM (
+			SELECT CASE
+			           WHEN f.mime_type LIKE 'image/%' THEN 'image'
+			           WHEN f.mime_type LIKE 'video/%' THEN 'video'
+			           WHEN f.mime_type LIKE 'audio/%' THEN 'audio'
+			           WHEN f.mime_type LIKE 'text/%'
+			             OR f.mime_type IN ('application/pdf', 'application/msword', 'application/rtf', 'application/json')
+			             OR f.mime_type LIKE 'application/vnd.openxmlformats-officedocument.%'
+			             OR f.mime_type LIKE 'application/vnd.oasis.opendocument.%'
+			             OR f.mime_type LIKE 'application/vnd.ms-%' THEN 'document'
+			           WHEN f.mime_type IN ('application/zip', 'application/gzip', 'application/x-gzip',
+			                                'application/x-tar', 'application/x-7z-compressed',
+			                                'application/x-rar-compressed', 'application/vnd.rar',
+			                                'application/x-bzip2', 'application/x-xz') THEN 'archive'
+			           ELSE 'other'
+			       END AS category,
+			       (SELECT COALESCE(SUM(v.size), 0) FROM file_versions v WHERE v.file_id = f.id) AS bytes
+			FROM files f
+			WHERE f.user_id = $1
+		) t
+		GROUP BY category
+		ORDER BY bytes DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	var extra struct {
+		TrashBytes    int64 `db:"trash_bytes"`
+		TrashFiles    int64 `db:"trash_files"`
+		VersionBytes  int64 `db:"version_bytes"`
+		VersionsCount int64 `db:"versions_count"`
+	}
+	err = h.db.GetContext(ctx, &extra, `
+		SELECT
+		    COALESCE(SUM(v.size) FILTER (WHERE f.deleted_at IS NOT NULL), 0) AS trash_bytes,
+		    COUNT(DISTINCT f.id) FILTER (WHERE f.deleted_at IS NOT NULL) AS trash_files,
+		    COALESCE(SUM(v.size) FILTER (WHERE f.deleted_at IS NULL AND v.version <> f.current_version), 0) AS version_bytes,
+		    COUNT(*) FILTER (WHERE f.deleted_at IS NULL AND v.version <> f.current_version) AS versions_count
+		FROM files f
+		JOIN file_versions v ON v.file_id = f.id
+		WHERE f.user_id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan": qt.Plan,
+		"bytes": gin.H{
+			"used":      qt.UsedBytes,
+			"limit":     nullableLimit(qt.MaxBytes),
+			"available": available(qt.MaxBytes, qt.UsedBytes),
+		},
+		"files": gin.H{
+			"used":      qt.UsedFiles,
+			"limit":     nullableLimit(qt.MaxFiles),
+			"available": available(qt.MaxFiles, qt.UsedFiles),
+		},
+		"by_category": categories,
+		"trash": gin.H{
+			"bytes": extra.TrashBytes,
+			"files": extra.TrashFiles,
+		},
+		"previous_versions": gin.H{
+			"bytes":    extra.VersionBytes,
+			"versions": extra.VersionsCount,
+		},
+	})
+}
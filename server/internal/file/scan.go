@@ -0,0 +1,380 @@
+package file
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/scanner"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Scan states recorded in files.scan_status and file_versions.scan_status.
+const (
+	scanPending  = "pending"
+	scanClean    = "clean"
+	scanInfected = "infected"
+	scanError    = "error"
+)
+
+const (
+	scanTimeout = 10 * time.Minute
+	// scanStaleAfter is how long a file may stay pending before the sweep
+	// assumes its scan was lost (e.g. to a restart) and runs it again.
+	scanStaleAfter = 10 * time.Minute
+	scanSweepBatch = 500
+	// A scan that failed for a transient reason is retried after
+	// scanRetryBase, doubling with each attempt up to scanRetryMax
+	scanRetryBase = time.Minute
+	scanRetryMax  = 6 * time.Hour
+)
+
+// scanBlocked writes an error response unless the file's content has been
+// scanned clean.
+func scanBlocked(c *gin.Context, status string) bool {
+	switch status {
+	case scanClean:
+		return false
+	case scanPending:
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusConflict, gin.H{"error": "File is still being scanned"})
+	case scanInfected:
+		c.JSON(http.StatusForbidden, gin.H{"error": "File is quarantined"})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "File could not be scanned"})
+	}
+	return true
+}
+
+// scanFile runs the configured scanner over a file's current content and
+// records the verdict. It runs after the upload response has been sent.
+func (h *FileHandler) scanFile(fileID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+
+	if err := h.scan(ctx, fileID); err != nil {
+		log.Printf("Failed to scan file %s: %v", fileID, err)
+	}
+}
+
+func (h *FileHandler) scan(ctx context.Context, fileID uuid.UUID) error {
+	var file struct {
+		StorageType string `db:"storage_type"`
+		StoragePath string `db:"storage_path"`
+	}
+	err := h.db.GetContext(ctx, &file, `
+		SELECT storage_type, storage_path FROM files WHERE id = $1`, fileID)
+	if err != nil {
+		return err
+	}
+	return h.scanObject(ctx, fileID, file.StorageType, file.StoragePath)
+}
+
+// scanObject scans one of a file's stored objects and records the verdict
+// on every version sharing it, and on the file if it is still current.
+func (h *FileHandler) scanObject(ctx context.Context, fileID uuid.UUID, storageType, storagePath string) error {
+	status, signature := scanError, sql.NullString{}
+	store, err := h.storage.Backend(storageType)
+	if err == nil {
+		r, openErr := store.Get(ctx, storagePath)
+		if err = openErr; err == nil {
+			result, scanErr := h.scanner.Scan(ctx, r)
+			r.Close()
+			if err = scanErr; err == nil {
+				status = scanClean
+				if result.Infected {
+					status = scanInfected
+					signature = sql.NullString{String: result.Signature, Valid: true}
+				}
+			}
+		}
+	}
+	var replyErr *scanner.ReplyError
+	if err != nil && !errors.As(err, &replyErr) {
+		// Scanner or storage unavailable: leave the content pending and
+		// let the sweep try again later
+		log.Printf("Scan of file %s failed, will retry: %v", fileID, err)
+		return h.deferScan(ctx, fileID, storagePath)
+	}
+	if err != nil {
+		log.Printf("Scan of file %s failed: %v", fileID, err)
+	}
+
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE file_versions SET scan_status = $1, scan_attempts = 0, scan_retry_at = NULL
+		WHERE file_id = $2 AND storage_path = $3`, status, fileID, storagePath)
+	if err != nil {
+		return err
+	}
+
+	// A newer version may have replaced the content meanwhile; it gets its
+	// own scan
+	var ownerID string
+	err = h.db.GetContext(ctx, &ownerID, `
+		UPDATE files
+		SET scan_status = $1, scan_signature = $2, scanned_at = NOW(),
+		    scan_reviewed_by = NULL, scan_reviewed_at = NULL,
+		    scan_attempts = 0, scan_retry_at = NULL
+		WHERE id = $3 AND storage_path = $4
+		RETURNING user_id`, status, signature, fileID, storagePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Listings show the status
+	h.invalidateFileCache(ctx, ownerID)
+	h.invalidateShareCache(ctx, fileID.String())
+	if status == scanInfected {
+		log.Printf("File %s quarantined: %s", fileID, signature.String)
+	}
+	return nil
+}
+
+// deferScan schedules another attempt at scanning an object that is still
+// pending, backing off exponentially.
+func (h *FileHandler) deferScan(ctx context.Context, fileID uuid.UUID, storagePath string) error {
+	const retry = `
+		scan_attempts = scan_attempts + 1,
+		scan_retry_at = NOW() + LEAST($3 * power(2, LEAST(scan_attempts, 20)), $4) * interval '1 second'`
+	base, max := scanRetryBase.Seconds(), scanRetryMax.Seconds()
+	_, err := h.db.ExecContext(ctx, `
+		UPDATE file_versions SET`+retry+`
+		WHERE file_id = $1 AND storage_path = $2 AND scan_status = 'pending'`, fileID, storagePath, base, max)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE files SET`+retry+`
+		WHERE id = $1 AND storage_path = $2 AND scan_status = 'pending'`, fileID, storagePath, base, max)
+	return err
+}
+
+// ScanPendingFiles rescans files whose scan never finished or is due for a
+// retry, then older versions that haven't been scanned.
+func (h *FileHandler) ScanPendingFiles(ctx context.Context) error {
+	var fileIDs []uuid.UUID
+	err := h.db.SelectContext(ctx, &fileIDs, `
+		SELECT id FROM files
+		WHERE scan_status = 'pending'
+		  AND COALESCE(scan_retry_at, COALESCE(updated_at, created_at) + $1 * interval '1 second') < NOW()
+		ORDER BY updated_at
+		LIMIT $2`, scanStaleAfter.Seconds(), scanSweepBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, fileID := range fileIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+		if err := h.scan(scanCtx, fileID); err != nil {
+			log.Printf("Failed to scan file %s: %v", fileID, err)
+		}
+		cancel()
+	}
+
+	var objects []struct {
+		FileID      uuid.UUID `db:"file_id"`
+		StorageType string    `db:"storage_type"`
+		StoragePath string    `db:"storage_path"`
+	}
+	err = h.db.SelectContext(ctx, &objects, `
+		SELECT v.file_id, v.storage_type, v.storage_path
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE v.scan_status = 'pending'
+		  AND COALESCE(v.scan_retry_at, v.created_at + $1 * interval '1 second') < NOW()
+		  AND v.storage_path <> f.storage_path
+		GROUP BY v.file_id, v.storage_type, v.storage_path
+		ORDER BY MIN(v.created_at)
+		LIMIT $2`, scanStaleAfter.Seconds(), scanSweepBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+		if err := h.scanObject(scanCtx, obj.FileID, obj.StorageType, obj.StoragePath); err != nil {
+			log.Printf("Failed to scan file %s: %v", obj.FileID, err)
+		}
+		cancel()
+	}
+	return nil
+}
+
+// RunScanSweep calls ScanPendingFiles now and then every interval until
+// ctx is done.
+func (h *FileHandler) RunScanSweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.ScanPendingFiles(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to sweep pending scans: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListQuarantine lists files that aren't clean for admin review. ?status=
+// narrows it to infected, error or pending; the default is infected and
+// error.
+func (h *FileHandler) ListQuarantine(c *gin.Context) {
+	statuses := []string{scanInfected, scanError}
+	switch status := c.Query("status"); status {
+	case "":
+	case scanInfected, scanError, scanPending:
+		statuses = []string{status}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	type quarantinedFile struct {
+		ID            uuid.UUID  `json:"id" db:"id"`
+		OwnerID       uuid.UUID  `json:"owner_id" db:"user_id"`
+		OwnerEmail    string     `json:"owner" db:"owner_email"`
+		OriginalName  string     `json:"filename" db:"original_name"`
+		Size          int64      `json:"size" db:"size"`
+		MimeType      string     `json:"mime_type" db:"mime_type"`
+		Checksum      *string    `json:"checksum" db:"checksum"`
+		ScanStatus    string     `json:"scan_status" db:"scan_status"`
+		ScanSignature *string    `json:"scan_signature" db:"scan_signature"`
+		ScannedAt     *time.Time `json:"scanned_at" db:"scanned_at"`
+		CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+		DeletedAt     *time.Time `json:"deleted_at" db:"deleted_at"`
+	}
+
+	files := []quarantinedFile{}
+	err := h.db.Select(&files, `
+		SELECT f.id, f.user_id, u.email AS owner_email, f.original_name, f.size,
+		       f.mime_type, f.checksum, f.scan_status, f.scan_signature, f.scanned_at,
+		       f.created_at, f.deleted_at
+		FROM files f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.scan_status = ANY($1)
+		ORDER BY f.scanned_at DESC NULLS LAST, f.created_at DESC
+		LIMIT 500`, pq.Array(statuses))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list quarantined files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// getQuarantinedFile loads the :file_id parameter for the admin endpoints,
+// which act on any file regardless of owner or trash state.
+func (h *FileHandler) getQuarantinedFile(c *gin.Context) (uuid.UUID, string, bool) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return uuid.Nil, "", false
+	}
+
+	var status string
+	err = h.db.Get(&status, `SELECT scan_status FROM files WHERE id = $1`, fileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return uuid.Nil, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return uuid.Nil, "", false
+	}
+	return fileID, status, true
+}
+
+// ReleaseQuarantinedFile marks a file clean after admin review, e.g. a
+// false positive or a scan error on a file known to be safe.
+func (h *FileHandler) ReleaseQuarantinedFile(c *gin.Context) {
+	fileID, status, ok := h.getQuarantinedFile(c)
+	if !ok {
+		return
+	}
+	if status == scanClean {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is not quarantined"})
+		return
+	}
+
+	var ownerID string
+	err := h.db.Get(&ownerID, `
+		WITH released AS (
+			UPDATE files
+			SET scan_status = 'clean', scan_reviewed_by = $1, scan_reviewed_at = NOW()
+			WHERE id = $2
+			RETURNING id, user_id, storage_path
+		), versions AS (
+			UPDATE file_versions v SET scan_status = 'clean'
+			FROM released r
+			WHERE v.file_id = r.id AND v.storage_path = r.storage_path
+		)
+		SELECT user_id FROM released`, c.GetString("userID"), fileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release file"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	h.invalidateFileCache(ctx, ownerID)
+	h.invalidateShareCache(ctx, fileID.String())
+	c.JSON(http.StatusOK, gin.H{"message": "File released from quarantine"})
+}
+
+// RescanQuarantinedFile runs the scanner again and returns the new status.
+func (h *FileHandler) RescanQuarantinedFile(c *gin.Context) {
+	fileID, _, ok := h.getQuarantinedFile(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), scanTimeout)
+	defer cancel()
+	if err := h.scan(ctx, fileID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan file"})
+		return
+	}
+
+	var status string
+	h.db.Get(&status, `SELECT scan_status FROM files WHERE id = $1`, fileID)
+	c.JSON(http.StatusOK, gin.H{"id": fileID, "scan_status": status})
+}
+
+// PurgeQuarantinedFile permanently deletes a quarantined file with all of
+// its versions.
+func (h *FileHandler) PurgeQuarantinedFile(c *gin.Context) {
+	fileID, status, ok := h.getQuarantinedFile(c)
+	if !ok {
+		return
+	}
+	if status == scanClean {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is not quarantined"})
+		return
+	}
+
+	var ownerID string
+	h.db.Get(&ownerID, `SELECT user_id FROM files WHERE id = $1`, fileID)
+	if err := h.purgeFile(c.Request.Context(), fileID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+
+	h.invalidateFileCache(c.Request.Context(), ownerID)
+	c.JSON(http.StatusOK, gin.H{"message": "File permanently deleted"})
+}
@@ -0,0 +1,96 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/YogendrasinghRathod/server/internal/redistest"
+	"github.com/YogendrasinghRathod/server/internal/scanner"
+	"github.com/YogendrasinghRathod/server/internal/sqltest"
+	"github.com/YogendrasinghRathod/server/internal/storage"
+	"github.com/google/uuid"
+)
+
+type scannerFunc func() (*scanner.Result, error)
+
+func (f scannerFunc) Scan(ctx context.Context, r io.Reader) (*scanner.Result, error) {
+	return f()
+}
+
+func TestScanObjectVerdicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *scanner.Result
+		err      error
+		status   string
+		deferred bool
+	}{
+		{"clean", &scanner.Result{}, nil, scanClean, false},
+		{"infected", &scanner.Result{Infected: true, Signature: "Eicar"}, nil, scanInfected, false},
+		{"scanner refused", nil, &scanner.ReplyError{Reply: "stream: size limit exceeded. ERROR"}, scanError, false},
+		{"scanner down", nil, errors.New("clamd: connection refused"), "", true},
+		{"timeout", nil, context.DeadlineExceeded, "", true},
+	}
+	for _, tt := range tests {
+		local, err := storage.NewLocal(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := local.Put(context.Background(), "blobs/ab/abc", strings.NewReader("data"), 4, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		db, fakeDB := sqltest.Open(t)
+		client, _ := redistest.NewClient(t)
+		h := &FileHandler{
+			storage:     storage.NewRegistry(local),
+			db:          db,
+			redisClient: client,
+			scanner:     scannerFunc(func() (*scanner.Result, error) { return tt.result, tt.err }),
+		}
+
+		if err := h.scanObject(context.Background(), uuid.New(), local.Type(), "blobs/ab/abc"); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		deferred := len(fakeDB.Ran("scan_retry_at = NOW()")) > 0
+		recorded := fakeDB.Ran("UPDATE file_versions SET scan_status")
+		if deferred != tt.deferred {
+			t.Errorf("%s: deferred = %v, want %v", tt.name, deferred, tt.deferred)
+		}
+		if tt.deferred {
+			if len(recorded) != 0 {
+				t.Errorf("%s: transient failure recorded a status", tt.name)
+			}
+			continue
+		}
+		if len(recorded) != 1 || recorded[0].Args[0] != tt.status {
+			t.Errorf("%s: recorded %v, want status %s", tt.name, recorded, tt.status)
+		}
+	}
+}
+
+func TestScanObjectMissingObject(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, fakeDB := sqltest.Open(t)
+	client, _ := redistest.NewClient(t)
+	h := &FileHandler{
+		storage:     storage.NewRegistry(local),
+		db:          db,
+		redisClient: client,
+		scanner:     scannerFunc(func() (*scanner.Result, error) { return &scanner.Result{}, nil }),
+	}
+
+	// Storage trouble is retried rather than marking the file unscannable
+	if err := h.scanObject(context.Background(), uuid.New(), local.Type(), "blobs/no/such"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fakeDB.Ran("scan_retry_at = NOW()")) == 0 || len(fakeDB.Ran("UPDATE file_versions SET scan_status")) != 0 {
+		t.Error("missing object was not left pending for a retry")
+	}
+}
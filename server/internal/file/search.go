@@ -0,0 +1,246 @@
+package file
+
+import (
+	"context"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/extract"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxIndexedText caps the extracted text stored per file.
+	maxIndexedText = 512 << 10
+	maxTags        = 32
+	maxTagLength   = 64
+	indexTimeout   = 5 * time.Minute
+)
+
+// indexContent extracts searchable text from a file's current object and
+// stores it for the search trigger. It runs after the upload response has
+// been sent, so failures are only logged.
+func (h *FileHandler) indexContent(fileID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+
+	var file struct {
+		OriginalName string `db:"original_name"`
+		MimeType     string `db:"mime_type"`
+		StoragePath  string `db:"storage_path"`
+		StorageType  string `db:"storage_type"`
+		Size         int64  `db:"size"`
+	}
+	err := h.db.GetContext(ctx, &file, `
+		SELECT original_name, mime_type, storage_path, storage_type, size
+		FROM files WHERE id = $1`, fileID)
+	if err != nil {
+		log.Printf("Failed to load file %s for indexing: %v", fileID, err)
+		return
+	}
+
+	var text *string
+	if extract.Supported(file.MimeType, file.OriginalName) {
+		store, err := h.storage.Backend(file.StorageType)
+		if err != nil {
+			log.Printf("Failed to index file %s: %v", fileID, err)
+			return
+		}
+		r, err := store.Get(ctx, file.StoragePath)
+		if err != nil {
+			log.Printf("Failed to index file %s: %v", fileID, err)
+			return
+		}
+		content, err := extract.Text(r, file.MimeType, file.OriginalName, file.Size, maxIndexedText)
+		r.Close()
+		if err != nil {
+			log.Printf("Failed to extract text from file %s: %v", fileID, err)
+		}
+		if content != "" {
+			text = &content
+		}
+	}
+
+	// Skip the write if a newer version replaced the object meanwhile;
+	// that version schedules its own indexing
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE files SET content_text = $1
+		WHERE id = $2 AND storage_path = $3 AND content_text IS DISTINCT FROM $1`,
+		text, fileID, file.StoragePath)
+	if err != nil {
+		log.Printf("Failed to store text for file %s: %v", fileID, err)
+	}
+}
+
+// normalizeTags lowercases, trims and de-duplicates tags, preserving order.
+func normalizeTags(tags []string) ([]string, bool) {
+	seen := make(map[string]bool, len(tags))
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, false
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out, len(out) <= maxTags
+}
+
+// SetTags replaces a file's tags.
+func (h *FileHandler) SetTags(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessEdit)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, ok := normalizeTags(req.Tags)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many tags or tag too long"})
+		return
+	}
+
+	_, err := h.db.Exec(`
+		UPDATE files SET tags = $1, updated_at = NOW()
+		WHERE id = $2`, pq.Array(tags), access.FileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+
+	h.invalidateFileCache(c.Request.Context(), access.OwnerID.String())
+	c.JSON(http.StatusOK, gin.H{"id": access.FileID, "tags": tags})
+}
+
+// ts_headline marks matches with these private-use characters; everything
+// else is escaped before they become <mark> tags, so names and content
+// from other users can't inject markup.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+var highlightTags = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+func highlight(headline string) string {
+	return highlightTags.Replace(html.EscapeString(headline))
+}
+
+// Search runs a ranked full-text query over every file the caller can view:
+// their own, files shared with them and files inside shared folders.
+// Matches in the name rank above tags, which rank above content.
+//
+// Query parameters: q (web search syntax: quotes, OR, -word), limit, offset.
+// Highlights are HTML-escaped, with matches wrapped in <mark>.
+func (h *FileHandler) Search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		offset = n
+	}
+
+	type searchResult struct {
+		ID            uuid.UUID      `json:"id" db:"id"`
+		OwnerID       uuid.UUID      `json:"owner_id" db:"user_id"`
+		FolderID      uuid.NullUUID  `json:"folder_id" db:"folder_id"`
+		OriginalName  string         `json:"filename" db:"original_name"`
+		Size          int64          `json:"size" db:"size"`
+		MimeType      string         `json:"mime_type" db:"mime_type"`
+		Tags          pq.StringArray `json:"tags" db:"tags"`
+		CreatedAt     time.Time      `json:"created_at" db:"created_at"`
+		Rank          float64        `json:"rank" db:"rank"`
+		NameHighlight string         `json:"name_highlight" db:"name_highlight"`
+		Snippet       string         `json:"snippet" db:"snippet"`
+	}
+
+	// Headlines are only computed for the page being returned
+	results := []searchResult{}
+	err := h.db.Select(&results, `
+		WITH RECURSIVE shared_folders AS (
+			SELECT folder_id AS id FROM folder_permissions
+			WHERE user_id = $1 AND (can_view OR can_edit)
+			UNION
+			SELECT fo.id FROM folders fo JOIN shared_folders s ON fo.parent_id = s.id
+		),
+		query AS (
+			SELECT websearch_to_tsquery('simple', $2) || websearch_to_tsquery('english', $2) AS tsq
+		),
+		hits AS (
+			SELECT f.id, f.user_id, f.folder_id, f.original_name, f.size, f.mime_type,
+			       f.tags, f.created_at, f.content_text,
+			       ts_rank_cd(f.search_vector, query.tsq) AS rank
+			FROM files f, query
+			WHERE f.deleted_at IS NULL
+			  AND f.search_vector @@ query.tsq
+			  AND (
+			      f.user_id = $1
+			      OR EXISTS (
+			          SELECT 1 FROM file_permissions p
+			          WHERE p.file_id = f.id AND p.user_id = $1 AND (p.can_view OR p.can_edit)
+			      )
+			      OR f.folder_id IN (SELECT id FROM shared_folders)
+			  )
+			ORDER BY rank DESC, f.created_at DESC, f.id
+			LIMIT $3 OFFSET $4
+		)
+		SELECT h.id, h.user_id, h.folder_id, h.original_name, h.size, h.mime_type,
+		       h.tags, h.created_at, h.rank,
+		       ts_headline('simple', h.original_name, query.tsq,
+		                   $5 || ', HighlightAll=true') AS name_highlight,
+		       COALESCE(ts_headline('english', h.content_text, query.tsq,
+		                   $5 || ', MaxFragments=2, MaxWords=30, MinWords=10'), '') AS snippet
+		FROM hits h, query
+		ORDER BY h.rank DESC, h.created_at DESC, h.id`,
+		c.GetString("userID"), q, limit, offset,
+		`StartSel="`+highlightStart+`", StopSel="`+highlightStop+`"`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+		return
+	}
+
+	for i := range results {
+		results[i].NameHighlight = highlight(results[i].NameHighlight)
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
@@ -0,0 +1,305 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/contentpolicy"
+	"github.com/YogendrasinghRathod/server/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRanges bounds a multi-range request; beyond it the whole object is
+// sent instead, as RFC 9110 allows.
+const maxRanges = 16
+
+// servedObject is one stored object as a download endpoint presents it.
+// ModTime is when the content (not the metadata) last changed.
+type servedObject struct {
+	StorageType string    `json:"storage_type" db:"storage_type"`
+	StoragePath string    `json:"storage_path" db:"storage_path"`
+	Checksum    string    `json:"checksum" db:"checksum"`
+	Name        string    `json:"name" db:"name"`
+	MimeType    string    `json:"mime_type" db:"mime_type"`
+	Size        int64     `json:"size" db:"size"`
+	ModTime     time.Time `json:"mod_time" db:"mod_time"`
+}
+
+// servedFileColumns selects a files row f as a servedObject, dated by its
+// current version.
+const servedFileColumns = `
+	f.storage_type, f.storage_path, COALESCE(f.checksum, '') AS checksum,
+	f.name, f.mime_type, f.size,
+	COALESCE(cv.created_at, f.updated_at, NOW()) AS mod_time`
+
+// servedFileJoin supplies the cv alias servedFileColumns needs.
+const servedFileJoin = `
+	LEFT JOIN file_versions cv ON cv.file_id = f.id AND cv.version = f.current_version`
+
+// etag is the strong validator for the object: its content hash. Objects
+// stored before checksums were recorded have none.
+func (o *servedObject) etag() string {
+	if o.Checksum == "" {
+		return ""
+	}
+	return `"` + o.Checksum + `"`
+}
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// parseRange parses a Range header against an object of the given size. It
+// returns nil ranges when the header should be ignored (absent, malformed,
+// a unit other than bytes, or too many ranges) and errUnsatisfiableRange
+// when no range overlaps the object.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// Suffix range: the final n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	if len(ranges) > maxRanges {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// etagMatches reports whether an If-None-Match list contains etag, using
+// the weak comparison the header calls for.
+func etagMatches(list, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when no
+// If-None-Match is sent, for a GET of obj.
+func notModified(c *gin.Context, obj *servedObject) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		return etagMatches(inm, obj.etag())
+	}
+	if ims := c.GetHeader("If-Modified-Since"); ims != "" && !obj.ModTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !obj.ModTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// rangeApplies evaluates If-Range: a range is only honored when the
+// client's validator still matches. ETags must match strongly; dates
+// must equal Last-Modified exactly.
+func rangeApplies(c *gin.Context, obj *servedObject) bool {
+	ifRange := c.GetHeader("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return obj.etag() != "" && ifRange == obj.etag()
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !obj.ModTime.IsZero() && obj.ModTime.Truncate(time.Second).Equal(t)
+}
+
+// serveObject streams a stored object from whichever backend holds it,
+// always as an attachment for active content. It answers conditional
+// requests (If-None-Match, If-Modified-Since) and single, multi and
+// If-Range byte ranges the same way for every backend. Full downloads are
+// verified against the checksum while streaming.
+func (h *FileHandler) serveObject(c *gin.Context, obj *servedObject, disposition string) {
+	store, err := h.storage.Backend(obj.StorageType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage backend unavailable"})
+		return
+	}
+
+	// 1. Safe headers: browsers must not second-guess the type, and active
+	// content (HTML, SVG, scripts) is never rendered in our origin
+	c.Header("X-Content-Type-Options", "nosniff")
+	if contentpolicy.IsActive(obj.MimeType, obj.Name) {
+		disposition = "attachment"
+		c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	}
+
+	// 2. Validators and conditional requests
+	c.Header("Accept-Ranges", "bytes")
+	if etag := obj.etag(); etag != "" {
+		c.Header("ETag", etag)
+		c.Header("X-Checksum-SHA256", obj.Checksum)
+	}
+	if !obj.ModTime.IsZero() {
+		c.Header("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c, obj) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// 3. Byte ranges
+	var ranges []byteRange
+	if header := c.GetHeader("Range"); header != "" && rangeApplies(c, obj) {
+		ranges, err = parseRange(header, obj.Size)
+		if errors.Is(err, errUnsatisfiableRange) {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Requested range not satisfiable"})
+			return
+		}
+	}
+
+	headers := map[string]string{
+		"Content-Disposition": mime.FormatMediaType(disposition, map[string]string{"filename": obj.Name}),
+	}
+	ctx := c.Request.Context()
+
+	switch len(ranges) {
+	case 0:
+		reader, err := store.Get(ctx, obj.StoragePath)
+		if !h.objectReadable(c, err) {
+			return
+		}
+		defer reader.Close()
+		c.DataFromReader(http.StatusOK, obj.Size, obj.MimeType,
+			newVerifyingReader(reader, obj.Checksum, obj.StoragePath), headers)
+
+	case 1:
+		r := ranges[0]
+		reader, err := store.GetRange(ctx, obj.StoragePath, r.start, r.length)
+		if !h.objectReadable(c, err) {
+			return
+		}
+		defer reader.Close()
+		headers["Content-Range"] = r.contentRange(obj.Size)
+		c.DataFromReader(http.StatusPartialContent, r.length, obj.MimeType, reader, headers)
+
+	default:
+		h.serveMultiRange(c, store, obj, ranges, headers)
+	}
+}
+
+// objectReadable writes the error response when opening an object failed.
+func (h *FileHandler) objectReadable(c *gin.Context, err error) bool {
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return false
+	}
+	return true
+}
+
+// serveMultiRange writes a multipart/byteranges response, opening each
+// range from the backend as it is reached.
+func (h *FileHandler) serveMultiRange(c *gin.Context, store storage.Storage, obj *servedObject, ranges []byteRange, headers map[string]string) {
+	ctx := c.Request.Context()
+
+	// Open the first part before committing to a 206
+	first, err := store.GetRange(ctx, obj.StoragePath, ranges[0].start, ranges[0].length)
+	if !h.objectReadable(c, err) {
+		return
+	}
+
+	mw := multipart.NewWriter(c.Writer)
+	for k, v := range headers {
+		c.Header(k, v)
+	}
+	c.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.Status(http.StatusPartialContent)
+
+	for i, r := range ranges {
+		reader := first
+		if i > 0 {
+			if reader, err = store.GetRange(ctx, obj.StoragePath, r.start, r.length); err != nil {
+				log.Printf("Failed to read range of %s: %v", obj.StoragePath, err)
+				return
+			}
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {obj.MimeType},
+			"Content-Range": {r.contentRange(obj.Size)},
+		})
+		if err == nil {
+			_, err = io.Copy(part, reader)
+		}
+		reader.Close()
+		if err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"", nil, nil},
+		{"items=0-1", nil, nil},
+		{"bytes=0-99", []byteRange{{0, 100}}, nil},
+		{"bytes=100-", []byteRange{{100, 900}}, nil},
+		{"bytes=-100", []byteRange{{900, 100}}, nil},
+		{"bytes=-5000", []byteRange{{0, 1000}}, nil},
+		{"bytes=900-5000", []byteRange{{900, 100}}, nil},
+		{"bytes=0-0, 999-999", []byteRange{{0, 1}, {999, 1}}, nil},
+		{"bytes= 0 - 9 ,", []byteRange{{0, 10}}, nil},
+		{"bytes=1000-", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"bytes=1000-2000, 5000-", nil, errUnsatisfiableRange},
+		{"bytes=5-1", nil, nil},
+		{"bytes=a-b", nil, nil},
+		{"bytes=-1-2", nil, nil},
+		{"bytes=10", nil, nil},
+		{"bytes=0-0,1-1,2-2,3-3,4-4,5-5,6-6,7-7,8-8,9-9,10-10,11-11,12-12,13-13,14-14,15-15,16-16", nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, 1000)
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRange(%q) = %v, %v; want %v, %v", tt.header, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRangeApplies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	obj := &servedObject{Checksum: "abc", ModTime: modTime}
+	legacy := &servedObject{ModTime: modTime}
+
+	tests := []struct {
+		name    string
+		ifRange string
+		obj     *servedObject
+		want    bool
+	}{
+		{"absent", "", obj, true},
+		{"matching etag", `"abc"`, obj, true},
+		{"other etag", `"def"`, obj, false},
+		{"weak etag", `W/"abc"`, obj, false},
+		{"etag without checksum", `"abc"`, legacy, false},
+		{"matching date", modTime.Format(http.TimeFormat), obj, true},
+		{"later date", modTime.Add(time.Hour).Format(http.TimeFormat), obj, false},
+		{"earlier date", modTime.Add(-time.Hour).Format(http.TimeFormat), obj, false},
+		{"no mod time", modTime.Format(http.TimeFormat), &servedObject{}, false},
+		{"garbage", "yesterday", obj, false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		if tt.ifRange != "" {
+			c.Request.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := rangeApplies(c, tt.obj); got != tt.want {
+			t.Errorf("%s: rangeApplies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
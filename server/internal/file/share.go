@@ -0,0 +1,307 @@
+package file
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultShareExpiry = 7 * 24 * time.Hour
+
+type createShareRequest struct {
+	// ExpiresAt overrides the default 7-day expiry; NoExpiry creates a
+	// link that stays valid until revoked.
+	ExpiresAt    *time.Time `json:"expires_at"`
+	NoExpiry     bool       `json:"no_expiry"`
+	Password     string     `json:"password" binding:"omitempty,min=4"`
+	MaxDownloads *int       `json:"max_downloads" binding:"omitempty,min=1"`
+}
+
+type shareLinkResponse struct {
+	Token         string        `json:"token" db:"token"`
+	ShareURL      string        `json:"share_url" db:"share_url"`
+	CreatedBy     uuid.NullUUID `json:"created_by" db:"created_by"`
+	CreatedAt     *time.Time    `json:"created_at" db:"created_at"`
+	ExpiresAt     *time.Time    `json:"expires_at" db:"expires_at"`
+	HasPassword   bool          `json:"has_password" db:"has_password"`
+	MaxDownloads  *int          `json:"max_downloads" db:"max_downloads"`
+	DownloadCount int           `json:"download_count" db:"download_count"`
+}
+
+func (h *FileHandler) CreateShareLink(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessShare)
+	if !ok {
+		return
+	}
+
+	// Only content that scanned clean can be shared publicly
+	var scanStatus string
+	if err := h.db.Get(&scanStatus, `SELECT scan_status FROM files WHERE id = $1`, access.FileID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if scanBlocked(c, scanStatus) {
+		return
+	}
+
+	if h.requireShare2FA {
+		var enabled bool
+		err := h.db.Get(&enabled, `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1`, c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if !enabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required to create share links"})
+			return
+		}
+	}
+
+	// 1. Parse options; an empty body keeps the defaults
+	var req createShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 2. Work out the expiry
+	expiresAt := sql.NullTime{Time: time.Now().Add(defaultShareExpiry), Valid: true}
+	switch {
+	case req.NoExpiry:
+		expiresAt = sql.NullTime{}
+	case req.ExpiresAt != nil:
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	// 3. Hash the optional password
+	var passwordHash sql.NullString
+	if req.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+			return
+		}
+		passwordHash = sql.NullString{String: string(hashed), Valid: true}
+	}
+
+	token := uuid.New().String()
+	_, err := h.db.Exec(`
+		INSERT INTO file_shares (file_id, token, expires_at, created_by, password_hash, max_downloads)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		access.FileID, token, expiresAt, c.GetString("userID"), passwordHash, req.MaxDownloads)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	resp := gin.H{
+		"token":         token,
+		"share_url":     "/share/" + token,
+		"expires_at":    nil,
+		"has_password":  passwordHash.Valid,
+		"max_downloads": req.MaxDownloads,
+	}
+	if expiresAt.Valid {
+		resp["expires_at"] = expiresAt.Time.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListShareLinks returns the file's links that can still be used.
+func (h *FileHandler) ListShareLinks(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessShare)
+	if !ok {
+		return
+	}
+
+	links := []shareLinkResponse{}
+	err := h.db.Select(&links, `
+		SELECT token, '/share/' || token AS share_url, created_by, created_at, expires_at,
+		       password_hash IS NOT NULL AS has_password, max_downloads, download_count
+		FROM file_shares
+		WHERE file_id = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_downloads IS NULL OR download_count < max_downloads)
+		ORDER BY created_at DESC`, access.FileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get share links"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"share_links": links})
+}
+
+// RevokeShareLink disables a link immediately, including any cached lookup.
+func (h *FileHandler) RevokeShareLink(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessShare)
+	if !ok {
+		return
+	}
+
+	token := c.Param("token")
+	result, err := h.db.Exec(`
+		UPDATE file_shares SET revoked_at = NOW()
+		WHERE file_id = $1 AND token = $2 AND revoked_at IS NULL`, access.FileID, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	h.redisClient.Del(c.Request.Context(), "file_share:"+token)
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
+}
+
+// sharedFile is what ServeSharedFile caches under file_share:<token>. The
+// link's limits are cached with it so an expired link is never served from
+// cache; revocation deletes the entry outright. The password hash is never
+// cached, only whether there is one.
+type sharedFile struct {
+	servedObject
+	OriginalName string         `json:"original_name" db:"original_name"`
+	ScanStatus   string         `json:"scan_status" db:"scan_status"`
+	ExpiresAt    sql.NullTime   `json:"expires_at" db:"expires_at"`
+	PasswordHash sql.NullString `json:"-" db:"password_hash"`
+	HasPassword  bool           `json:"has_password" db:"has_password"`
+	MaxDownloads sql.NullInt64  `json:"max_downloads" db:"max_downloads"`
+	RevokedAt    sql.NullTime   `json:"-" db:"revoked_at"`
+}
+
+func (h *FileHandler) lookupShare(ctx context.Context, token string) (*sharedFile, error) {
+	cacheKey := "file_share:" + token
+	var file sharedFile
+	if cached, err := h.redisClient.Get(ctx, cacheKey).Result(); err == nil && json.Unmarshal([]byte(cached), &file) == nil {
+		return &file, nil
+	}
+
+	err := h.db.Get(&file, `
+		SELECT `+servedFileColumns+`, f.original_name, f.scan_status,
+		       s.expires_at, s.password_hash, s.password_hash IS NOT NULL AS has_password,
+		       s.max_downloads, s.revoked_at
+		FROM file_shares s
+		JOIN files f ON s.file_id = f.id`+servedFileJoin+`
+		WHERE s.token = $1 AND f.deleted_at IS NULL`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never cache past the link's own expiry
+	ttl := time.Hour
+	if file.ExpiresAt.Valid {
+		if remaining := time.Until(file.ExpiresAt.Time); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if !file.RevokedAt.Valid && ttl > 0 {
+		if data, err := json.Marshal(file); err == nil {
+			h.redisClient.Set(ctx, cacheKey, data, ttl)
+		}
+	}
+	return &file, nil
+}
+
+// openShare resolves the :token route parameter to its file, checking the
+// link is live and the password matches. It writes the error response
+// itself.
+func (h *FileHandler) openShare(c *gin.Context) (*sharedFile, bool) {
+	// Resolve the link
+	file, err := h.lookupShare(c.Request.Context(), c.Param("token"))
+	if err != nil || file.RevokedAt.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid share link"})
+		return nil, false
+	}
+
+	if file.ExpiresAt.Valid && time.Now().After(file.ExpiresAt.Time) {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link expired"})
+		return nil, false
+	}
+
+	// Check the password, which only comes in a header so it stays out of
+	// URLs and access logs. A cached link has no hash, so fetch it.
+	if file.HasPassword {
+		password := c.GetHeader("X-Share-Password")
+		if password == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required"})
+			return nil, false
+		}
+		if !file.PasswordHash.Valid {
+			err := h.db.Get(&file.PasswordHash, `SELECT password_hash FROM file_shares WHERE token = $1`, c.Param("token"))
+			if err != nil || !file.PasswordHash.Valid {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Invalid share link"})
+				return nil, false
+			}
+		}
+		if bcrypt.CompareHashAndPassword([]byte(file.PasswordHash.String), []byte(password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return nil, false
+		}
+	}
+	return file, true
+}
+
+// shareResumeWindow is how long after a counted download the same client
+// may fetch ranges of the file again without counting another download.
+const shareResumeWindow = 15 * time.Minute
+
+func shareResumeKey(token, ip string) string {
+	return "share_resume:" + token + ":" + ip
+}
+
+func (h *FileHandler) ServeSharedFile(c *gin.Context) {
+	token := c.Param("token")
+	ctx := context.Background()
+
+	file, ok := h.openShare(c)
+	if !ok || scanBlocked(c, file.ScanStatus) {
+		return
+	}
+
+	// Every response with content counts against the limit, except ranges
+	// a client fetches soon after one of its downloads was counted, so an
+	// interrupted download can resume. Revalidations send no content.
+	obj := &file.servedObject
+	resumeKey := shareResumeKey(token, c.ClientIP())
+	counted := !notModified(c, obj)
+	if counted && c.GetHeader("Range") != "" {
+		if n, err := h.redisClient.Exists(ctx, resumeKey).Result(); err == nil && n > 0 {
+			counted = false
+		}
+	}
+	if counted && file.MaxDownloads.Valid {
+		var count int
+		err := h.db.Get(&count, `
+			UPDATE file_shares SET download_count = download_count + 1
+			WHERE token = $1 AND revoked_at IS NULL
+			  AND (max_downloads IS NULL OR download_count < max_downloads)
+			RETURNING download_count`, token)
+		if err != nil {
+			h.redisClient.Del(ctx, "file_share:"+token)
+			c.JSON(http.StatusGone, gin.H{"error": "Share link download limit reached"})
+			return
+		}
+	} else if counted {
+		h.db.Exec(`UPDATE file_shares SET download_count = download_count + 1 WHERE token = $1`, token)
+	}
+	if counted {
+		h.redisClient.Set(ctx, resumeKey, 1, shareResumeWindow)
+	}
+
+	h.serveObject(c, obj, "inline")
+}
@@ -0,0 +1,118 @@
+package file
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YogendrasinghRathod/server/internal/redistest"
+	"github.com/YogendrasinghRathod/server/internal/sqltest"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestServeSharedFilePastLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"full", nil},
+		{"suffix range", map[string]string{"Range": "bytes=1-"}},
+		{"multi range", map[string]string{"Range": "bytes=1-,0-0"}},
+		{"range with stale If-Range", map[string]string{"Range": "bytes=1-", "If-Range": `"other"`}},
+	}
+	for _, tt := range tests {
+		db, fakeDB := sqltest.Open(t)
+		client, fakeRedis := redistest.NewClient(t)
+		h := &FileHandler{db: db, redisClient: client}
+
+		// The link allows one download, already used; the limit check
+		// in the UPDATE finds no row
+		cached, _ := json.Marshal(sharedFile{
+			servedObject: servedObject{Checksum: "abc", Name: "a.txt", MimeType: "text/plain", Size: 1000},
+			ScanStatus:   scanClean,
+			MaxDownloads: sql.NullInt64{Int64: 1, Valid: true},
+		})
+		fakeRedis.Set("file_share:tok", string(cached))
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/share/tok", nil)
+		for k, v := range tt.headers {
+			c.Request.Header.Set(k, v)
+		}
+		c.Params = gin.Params{{Key: "token", Value: "tok"}}
+		h.ServeSharedFile(c)
+
+		if w.Code != http.StatusGone {
+			t.Errorf("%s: status = %d, want 410", tt.name, w.Code)
+		}
+		if len(fakeDB.Ran("UPDATE file_shares SET download_count")) != 1 {
+			t.Errorf("%s: download was not counted", tt.name)
+		}
+		if _, ok := fakeRedis.Get(shareResumeKey("tok", c.ClientIP())); ok {
+			t.Errorf("%s: refused download allows resuming", tt.name)
+		}
+	}
+}
+
+func TestSharePasswordNotCached(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   int
+	}{
+		{"no password", "/share/tok", "", http.StatusUnauthorized},
+		{"query parameter", "/share/tok?password=secret", "", http.StatusUnauthorized},
+		{"wrong header", "/share/tok", "guess", http.StatusUnauthorized},
+		{"header", "/share/tok", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		db, fakeDB := sqltest.Open(t)
+		client, fakeRedis := redistest.NewClient(t)
+		h := &FileHandler{db: db, redisClient: client}
+
+		cached, _ := json.Marshal(sharedFile{
+			servedObject: servedObject{Checksum: "abc", Name: "a.txt", MimeType: "text/plain", Size: 1},
+			ScanStatus:   scanClean,
+			PasswordHash: sql.NullString{String: string(hash), Valid: true},
+			HasPassword:  true,
+		})
+		if strings.Contains(string(cached), "$2a$") {
+			t.Fatalf("cached share contains the password hash: %s", cached)
+		}
+		fakeRedis.Set("file_share:tok", string(cached))
+		fakeDB.On("SELECT password_hash FROM file_shares", sqltest.Row([]string{"password_hash"}, string(hash)))
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", tt.url, nil)
+		if tt.header != "" {
+			c.Request.Header.Set("X-Share-Password", tt.header)
+		}
+		c.Params = gin.Params{{Key: "token", Value: "tok"}}
+		file, ok := h.openShare(c)
+
+		status := w.Code
+		if ok {
+			status = http.StatusOK
+			if file.PasswordHash.String != string(hash) {
+				t.Errorf("%s: hash was not read from the database", tt.name)
+			}
+		}
+		if status != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
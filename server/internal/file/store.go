@@ -0,0 +1,153 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// storedFile is the metadata recorded for a newly stored file.
+type storedFile struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	FolderID     uuid.NullUUID
+	Name         string
+	OriginalName string
+	StoragePath  string
+	StorageType  string
+	Size         int64
+	MimeType     string
+	Checksum     string
+	URL          string
+	CreatedAt    time.Time
+}
+
+func (f *storedFile) response() gin.H {
+	return gin.H{
+		"id":          f.ID,
+		"user_id":     f.UserID,
+		"folder_id":   f.FolderID,
+		"name":        f.Name,
+		"storage":     f.StorageType,
+		"size":        f.Size,
+		"mime_type":   f.MimeType,
+		"checksum":    f.Checksum,
+		"scan_status": scanPending,
+		"created_at":  f.CreatedAt.Format(time.RFC3339),
+		"is_public":   false,
+	}
+}
+
+// storeFile stores r on the primary storage backend, deduplicated by
+// content, and records it in the files table, owned by userID and placed in
+// folderID (NULL for the top level). Every upload path (multipart, tus)
+// goes through here so the resulting rows look the same. It fails with
+// errQuotaExceeded when the file would take the owner over their storage
+// quota.
+func (h *FileHandler) storeFile(ctx context.Context, userID uuid.UUID, folderID uuid.NullUUID, originalName, mimeType string, size int64, r io.Reader) (*storedFile, error) {
+	obj, err := h.putObject(ctx, originalName, mimeType, size, r)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize this owner's uploads so the quota check below is exact
+	if err := lockUser(ctx, tx, userID); err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, fmt.Errorf("failed to lock owner: %w", err)
+	}
+
+	fileID := uuid.New()
+	url := fmt.Sprintf("/files/%s", fileID)
+
+	_, err = tx.Exec(`
+		INSERT INTO files (
+			id, user_id, name, original_name, storage_path,
+			storage_type, size, mime_type, is_public, url, folder_id, checksum
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		fileID,
+		userID,
+		obj.Name,
+		originalName,
+		obj.StoragePath,
+		obj.StorageType,
+		size,
+		mimeType,
+		false,
+		url,
+		folderID,
+		obj.Checksum,
+	)
+	if err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO file_versions (
+			file_id, version, storage_path, storage_type, mime_type, size, checksum, created_by
+		) VALUES ($1, 1, $2, $3, $4, $5, $6, $7)`,
+		fileID, obj.StoragePath, obj.StorageType, mimeType, size, obj.Checksum, userID,
+	)
+	if err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, fmt.Errorf("failed to store file version: %w", err)
+	}
+
+	if err := enforceQuota(ctx, tx, userID); err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		return nil, fmt.Errorf("transaction failed: %w", err)
+	}
+	h.invalidateFileCache(ctx, userID.String())
+	go h.indexContent(fileID)
+	go h.generateThumbnails(fileID)
+	go h.scanFile(fileID)
+
+	return &storedFile{
+		ID:           fileID,
+		UserID:       userID,
+		FolderID:     folderID,
+		Name:         obj.Name,
+		OriginalName: originalName,
+		StoragePath:  obj.StoragePath,
+		StorageType:  obj.StorageType,
+		Size:         size,
+		MimeType:     mimeType,
+		Checksum:     obj.Checksum,
+		URL:          url,
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+// invalidateFileCache drops every cached file listing page for a user by
+// moving them to a new cache generation; stale pages expire on their own.
+func (h *FileHandler) invalidateFileCache(ctx context.Context, userID string) {
+	h.redisClient.Incr(ctx, "user_files_gen:"+userID)
+}
+
+// invalidateShareCache drops cached share-link lookups for a file so the
+// next request sees its current content and state.
+func (h *FileHandler) invalidateShareCache(ctx context.Context, fileID string) {
+	var tokens []string
+	if err := h.db.SelectContext(ctx, &tokens, `SELECT token FROM file_shares WHERE file_id = $1`, fileID); err != nil {
+		return
+	}
+	for _, token := range tokens {
+		h.redisClient.Del(ctx, "file_share:"+token)
+	}
+}
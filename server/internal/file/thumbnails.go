@@ -0,0 +1,344 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/thumbnail"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxThumbnailSource is the largest original thumbnails are made from.
+	maxThumbnailSource = 64 << 20
+	thumbnailTimeout   = 5 * time.Minute
+	// thumbnailStaleAfter is how long a blob may stay pending before the
+	// sweep assumes its job was lost and queues it again. A live job gives
+	// up after thumbnailTimeout.
+	thumbnailStaleAfter = 15 * time.Minute
+	thumbnailSweepBatch = 100
+)
+
+// thumbnailSlots bounds concurrent image decodes, which are memory heavy.
+var thumbnailSlots = make(chan struct{}, 2)
+
+func thumbnailKey(checksum, size, contentType string) string {
+	ext := ".jpg"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	return path.Join("thumbnails", checksum[:2], checksum, size+ext)
+}
+
+// generateThumbnails renders every thumbnail size for a file's current
+// content. Thumbnails hang off the blob, so content that already has
+// them (a duplicate upload or a restored version) is skipped. It runs
+// after the upload response has been sent, so failures are only logged.
+func (h *FileHandler) generateThumbnails(fileID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+	defer cancel()
+
+	var file struct {
+		StorageType string         `db:"storage_type"`
+		StoragePath string         `db:"storage_path"`
+		Checksum    sql.NullString `db:"checksum"`
+		MimeType    string         `db:"mime_type"`
+		Size        int64          `db:"size"`
+	}
+	err := h.db.GetContext(ctx, &file, `
+		SELECT storage_type, storage_path, checksum, mime_type, size
+		FROM files WHERE id = $1`, fileID)
+	if err != nil {
+		log.Printf("Failed to load file %s for thumbnails: %v", fileID, err)
+		return
+	}
+	if !file.Checksum.Valid || !thumbnail.Supported(file.MimeType) {
+		return
+	}
+	checksum := file.Checksum.String
+
+	// Claim the blob so concurrent uploads of the same image render once
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE blobs SET thumbnail_status = 'pending', thumbnail_claimed_at = NOW()
+		WHERE storage_type = $1 AND checksum = $2 AND thumbnail_status IS NULL`,
+		file.StorageType, checksum)
+	if err != nil {
+		log.Printf("Failed to claim thumbnails for %s: %v", checksum, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	// Every way out records a verdict; a job that dies without one is
+	// picked up by RequeueStaleThumbnails
+	status := "failed"
+	defer func() {
+		_, err := h.db.Exec(`
+			UPDATE blobs SET thumbnail_status = $1
+			WHERE storage_type = $2 AND checksum = $3`, status, file.StorageType, checksum)
+		if err != nil {
+			log.Printf("Failed to record thumbnail status for %s: %v", checksum, err)
+		}
+	}()
+
+	if file.Size > maxThumbnailSource {
+		return
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+		defer func() { <-thumbnailSlots }()
+	case <-ctx.Done():
+		log.Printf("Failed to generate thumbnails for %s: %v", checksum, ctx.Err())
+		return
+	}
+
+	store, err := h.storage.Backend(file.StorageType)
+	if err != nil {
+		log.Printf("Failed to generate thumbnails for %s: %v", checksum, err)
+		return
+	}
+	r, err := store.Get(ctx, file.StoragePath)
+	if err != nil {
+		log.Printf("Failed to generate thumbnails for %s: %v", checksum, err)
+		return
+	}
+	images, err := thumbnail.Generate(io.LimitReader(r, maxThumbnailSource))
+	r.Close()
+	if err != nil {
+		log.Printf("Failed to generate thumbnails for %s: %v", checksum, err)
+		return
+	}
+
+	// Thumbnails live on the same backend as their original
+	for _, img := range images {
+		key := thumbnailKey(checksum, img.Size, img.ContentType)
+		sum := sha256.Sum256(img.Data)
+		if err := store.Put(ctx, key, bytes.NewReader(img.Data), int64(len(img.Data)), img.ContentType); err != nil {
+			log.Printf("Failed to store thumbnail %s: %v", key, err)
+			return
+		}
+		_, err = h.db.ExecContext(ctx, `
+			INSERT INTO thumbnails (
+				storage_type, blob_checksum, size, storage_path, checksum,
+				content_type, width, height, bytes
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (storage_type, blob_checksum, size) DO UPDATE
+			SET storage_path = EXCLUDED.storage_path, checksum = EXCLUDED.checksum,
+			    content_type = EXCLUDED.content_type, width = EXCLUDED.width,
+			    height = EXCLUDED.height, bytes = EXCLUDED.bytes`,
+			file.StorageType, checksum, img.Size, key, hex.EncodeToString(sum[:]),
+			img.ContentType, img.Width, img.Height, len(img.Data))
+		if err != nil {
+			log.Printf("Failed to record thumbnail %s: %v", key, err)
+			return
+		}
+	}
+	status = "ready"
+}
+
+// RequeueStaleThumbnails regenerates thumbnails for blobs whose job was
+// claimed but never finished, e.g. because the server restarted.
+func (h *FileHandler) RequeueStaleThumbnails(ctx context.Context) error {
+	var stale []struct {
+		StorageType string `db:"storage_type"`
+		Checksum    string `db:"checksum"`
+	}
+	err := h.db.SelectContext(ctx, &stale, `
+		UPDATE blobs SET thumbnail_status = NULL, thumbnail_claimed_at = NULL
+		WHERE (storage_type, checksum) IN (
+			SELECT storage_type, checksum FROM blobs
+			WHERE thumbnail_status = 'pending'
+			  AND COALESCE(thumbnail_claimed_at, created_at) + $1 * interval '1 second' < NOW()
+			LIMIT $2
+		)
+		RETURNING storage_type, checksum`, thumbnailStaleAfter.Seconds(), thumbnailSweepBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range stale {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Only content that is some file's current version gets thumbnails
+		var fileID uuid.UUID
+		err := h.db.GetContext(ctx, &fileID, `
+			SELECT id FROM files
+			WHERE storage_type = $1 AND checksum = $2 AND deleted_at IS NULL
+			LIMIT 1`, blob.StorageType, blob.Checksum)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.generateThumbnails(fileID)
+	}
+	return nil
+}
+
+// RunThumbnailSweep calls RequeueStaleThumbnails every interval until ctx
+// is done.
+func (h *FileHandler) RunThumbnailSweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.RequeueStaleThumbnails(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to sweep pending thumbnails: %v", err)
+			}
+		}
+	}
+}
+
+// thumbnailSource is the slice of a files row needed to find its thumbnails.
+type thumbnailSource struct {
+	StorageType  string         `db:"storage_type"`
+	Checksum     sql.NullString `db:"checksum"`
+	MimeType     string         `db:"mime_type"`
+	OriginalName string         `db:"original_name"`
+	ScanStatus   string         `db:"scan_status"`
+}
+
+// serveThumbnail answers a ?size= thumbnail request for a file. Images
+// still being processed get 202 so clients can poll.
+func (h *FileHandler) serveThumbnail(c *gin.Context, src *thumbnailSource) {
+	size := c.DefaultQuery("size", thumbnail.DefaultSize)
+	if _, ok := thumbnail.LookupSize(size); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid thumbnail size"})
+		return
+	}
+	if scanBlocked(c, src.ScanStatus) {
+		return
+	}
+	if !src.Checksum.Valid || !thumbnail.Supported(src.MimeType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available for this file"})
+		return
+	}
+
+	var thumb struct {
+		servedObject
+		Status sql.NullString `db:"thumbnail_status"`
+	}
+	err := h.db.Get(&thumb, `
+		SELECT b.thumbnail_status,
+		       COALESCE(t.storage_type, b.storage_type) AS storage_type,
+		       COALESCE(t.storage_path, '') AS storage_path,
+		       COALESCE(t.checksum, '') AS checksum,
+		       COALESCE(t.content_type, '') AS mime_type,
+		       COALESCE(t.bytes, 0) AS size,
+		       COALESCE(t.created_at, b.created_at) AS mod_time
+		FROM blobs b
+		LEFT JOIN thumbnails t
+		       ON t.storage_type = b.storage_type AND t.blob_checksum = b.checksum AND t.size = $3
+		WHERE b.storage_type = $1 AND b.checksum = $2`,
+		src.StorageType, src.Checksum.String, size)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available for this file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get thumbnail"})
+		return
+	}
+
+	switch {
+	case thumb.StoragePath != "":
+		base := strings.TrimSuffix(src.OriginalName, path.Ext(src.OriginalName))
+		thumb.Name = base + "_" + size + path.Ext(thumb.StoragePath)
+		h.serveObject(c, &thumb.servedObject, "inline")
+	case thumb.Status.String == "failed":
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available for this file"})
+	default:
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusAccepted, gin.H{"status": "pending"})
+	}
+}
+
+// GetThumbnail serves a thumbnail of the file's current version.
+func (h *FileHandler) GetThumbnail(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessView)
+	if !ok {
+		return
+	}
+
+	var src thumbnailSource
+	err := h.db.Get(&src, `
+		SELECT storage_type, checksum, mime_type, original_name, scan_status
+		FROM files WHERE id = $1`, access.FileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	h.serveThumbnail(c, &src)
+}
+
+// GetSharedThumbnail serves a thumbnail through a share link. Previews
+// don't count as downloads, but stop once the link's downloads are used up.
+func (h *FileHandler) GetSharedThumbnail(c *gin.Context) {
+	file, ok := h.openShare(c)
+	if !ok {
+		return
+	}
+	if file.MaxDownloads.Valid {
+		var exhausted bool
+		err := h.db.Get(&exhausted, `
+			SELECT download_count >= max_downloads FROM file_shares WHERE token = $1`, c.Param("token"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get share link"})
+			return
+		}
+		if exhausted {
+			c.JSON(http.StatusGone, gin.H{"error": "Share link download limit reached"})
+			return
+		}
+	}
+	h.serveThumbnail(c, &thumbnailSource{
+		StorageType:  file.StorageType,
+		Checksum:     sql.NullString{String: file.Checksum, Valid: file.Checksum != ""},
+		MimeType:     file.MimeType,
+		OriginalName: file.OriginalName,
+		ScanStatus:   file.ScanStatus,
+	})
+}
+
+// releaseThumbnails deletes the stored thumbnails of a blob that is being
+// removed; their rows go with the blob row.
+func (h *FileHandler) releaseThumbnails(ctx context.Context, storageType, blobPath string) {
+	var paths []string
+	err := h.db.SelectContext(ctx, &paths, `
+		SELECT t.storage_path FROM thumbnails t
+		JOIN blobs b ON b.storage_type = t.storage_type AND b.checksum = t.blob_checksum
+		WHERE b.storage_type = $1 AND b.storage_path = $2`, storageType, blobPath)
+	if err != nil {
+		log.Printf("Failed to list thumbnails of %s: %v", blobPath, err)
+		return
+	}
+
+	store, err := h.storage.Backend(storageType)
+	if err != nil {
+		return
+	}
+	for _, p := range paths {
+		if err := store.Delete(ctx, p); err != nil {
+			log.Printf("Failed to delete thumbnail %s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package file
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/YogendrasinghRathod/server/internal/sqltest"
+	"github.com/YogendrasinghRathod/server/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestRequeueStaleThumbnails(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, fakeDB := sqltest.Open(t)
+	h := &FileHandler{storage: storage.NewRegistry(local), db: db}
+
+	checksum := strings.Repeat("ab", 32)
+	fileID := uuid.New()
+	fakeDB.On("UPDATE blobs SET thumbnail_status = NULL", sqltest.Row([]string{"storage_type", "checksum"}, local.Type(), checksum))
+	fakeDB.On("SELECT id FROM files", sqltest.Row([]string{"id"}, fileID.String()))
+	fakeDB.On("FROM files WHERE id", sqltest.Row(
+		[]string{"storage_type", "storage_path", "checksum", "mime_type", "size"},
+		local.Type(), "blobs/ab/"+checksum, checksum, "image/png", int64(10)))
+	fakeDB.On("thumbnail_claimed_at = NOW()", sqltest.Result{RowsAffected: 1})
+
+	// The blob's content is gone, so the rerun fails, and says so
+	if err := h.RequeueStaleThumbnails(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fakeDB.Ran("thumbnail_status = 'pending', thumbnail_claimed_at = NOW()")) != 1 {
+		t.Error("stale blob was not claimed again")
+	}
+	recorded := fakeDB.Ran("UPDATE blobs SET thumbnail_status = $1")
+	if len(recorded) != 1 || recorded[0].Args[0] != "failed" {
+		t.Errorf("recorded %v, want status failed", recorded)
+	}
+}
@@ -0,0 +1,215 @@
+package file
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DeleteFile moves a file to its owner's trash. Trashed files disappear
+// from listings, permission checks and share links until restored.
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	access, ok := h.authorizeFile(c, accessOwner)
+	if !ok {
+		return
+	}
+
+	_, err := h.db.Exec(`
+		UPDATE files SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`, access.FileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	h.invalidateFileCache(ctx, access.OwnerID.String())
+	h.invalidateShareCache(ctx, access.FileID.String())
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "File moved to trash",
+		"purge_after": time.Now().Add(h.trashRetention).Format(time.RFC3339),
+	})
+}
+
+func (h *FileHandler) ListTrash(c *gin.Context) {
+	type trashedFile struct {
+		ID           uuid.UUID `json:"id" db:"id"`
+		OriginalName string    `json:"filename" db:"original_name"`
+		Size         int64     `json:"size" db:"size"`
+		MimeType     string    `json:"mime_type" db:"mime_type"`
+		DeletedAt    time.Time `json:"deleted_at" db:"deleted_at"`
+		PurgeAfter   time.Time `json:"purge_after" db:"-"`
+	}
+
+	files := []trashedFile{}
+	err := h.db.Select(&files, `
+		SELECT id, original_name, size, mime_type, deleted_at
+		FROM files
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trash"})
+		return
+	}
+
+	for i := range files {
+		files[i].PurgeAfter = files[i].DeletedAt.Add(h.trashRetention)
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+func (h *FileHandler) RestoreFile(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("file_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		return
+	}
+
+	userID := c.GetString("userID")
+	result, err := h.db.Exec(`
+		UPDATE files SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, c.Param("file_id"), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore file"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		return
+	}
+
+	h.invalidateFileCache(c.Request.Context(), userID)
+	c.JSON(http.StatusOK, gin.H{"message": "File restored successfully"})
+}
+
+// PurgeTrashedFile permanently deletes a single trashed file.
+func (h *FileHandler) PurgeTrashedFile(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("file_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		return
+	}
+
+	var fileID uuid.UUID
+	err := h.db.Get(&fileID, `
+		SELECT id FROM files
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, c.Param("file_id"), c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		return
+	}
+
+	if err := h.purgeFile(c.Request.Context(), fileID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File permanently deleted"})
+}
+
+// EmptyTrash permanently deletes everything in the caller's trash.
+func (h *FileHandler) EmptyTrash(c *gin.Context) {
+	var fileIDs []uuid.UUID
+	err := h.db.Select(&fileIDs, `
+		SELECT id FROM files
+		WHERE user_id = $1 AND deleted_at IS NOT NULL`, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trash"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	purged := 0
+	for _, fileID := range fileIDs {
+		if err := h.purgeFile(ctx, fileID); err != nil {
+			log.Printf("Failed to purge file %s: %v", fileID, err)
+			continue
+		}
+		purged++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash emptied",
+		"purged":  purged,
+	})
+}
+
+// purgeFile removes a file row together with its versions and releases
+// the stored object reference each version held.
+func (h *FileHandler) purgeFile(ctx context.Context, fileID uuid.UUID) error {
+	var objects []struct {
+		StorageType string `db:"storage_type"`
+		StoragePath string `db:"storage_path"`
+	}
+	// One reference per version row; the files row mirrors the current one
+	err := h.db.SelectContext(ctx, &objects, `
+		SELECT storage_type, storage_path FROM file_versions WHERE file_id = $1`, fileID)
+	if err != nil {
+		return err
+	}
+
+	// Share tokens disappear with the row, so drop their cache entries first
+	h.invalidateShareCache(ctx, fileID.String())
+
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM file_shares WHERE file_id = $1`, fileID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE id = $1`, fileID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := h.releaseObject(ctx, obj.StorageType, obj.StoragePath); err != nil {
+			log.Printf("Failed to delete stored object %s: %v", obj.StoragePath, err)
+		}
+	}
+	return nil
+}
+
+// PurgeExpiredTrash permanently deletes files trashed longer than the
+// retention period.
+func (h *FileHandler) PurgeExpiredTrash(ctx context.Context) error {
+	var fileIDs []uuid.UUID
+	err := h.db.SelectContext(ctx, &fileIDs, `
+		SELECT id FROM files
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1`, time.Now().Add(-h.trashRetention))
+	if err != nil {
+		return err
+	}
+
+	for _, fileID := range fileIDs {
+		if err := h.purgeFile(ctx, fileID); err != nil {
+			log.Printf("Failed to purge file %s: %v", fileID, err)
+		}
+	}
+	return nil
+}
+
+// RunTrashPurge calls PurgeExpiredTrash every interval until ctx is done.
+func (h *FileHandler) RunTrashPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.PurgeExpiredTrash(ctx); err != nil {
+				log.Printf("Failed to purge trash: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package file
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YogendrasinghRathod/server/internal/contentpolicy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tus 1.0 resumable uploads (https://tus.io/protocols/resumable-upload).
+// Chunks are appended to a staging file on local disk while the offset is
+// tracked in tus_uploads, one PATCH at a time per upload; once the last
+// byte arrives the staged file goes through storeFile exactly like a
+// multipart upload. Staging files live on the instance that created the
+// upload, so with several instances tus requests need sticky routing.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+type tusUpload struct {
+	ID           uuid.UUID      `db:"id"`
+	UserID       uuid.UUID      `db:"user_id"`
+	UploadLength int64          `db:"upload_length"`
+	UploadOffset int64          `db:"upload_offset"`
+	OriginalName string         `db:"original_name"`
+	MimeType     string         `db:"mime_type"`
+	Metadata     sql.NullString `db:"metadata"`
+	FileID       uuid.NullUUID  `db:"file_id"`
+	FolderID     uuid.NullUUID  `db:"folder_id"`
+	ExpiresAt    time.Time      `db:"expires_at"`
+	CompletedAt  sql.NullTime   `db:"completed_at"`
+}
+
+func (h *FileHandler) stagingPath(uploadID uuid.UUID) string {
+	return filepath.Join(h.tusDir, uploadID.String())
+}
+
+// checkTusResumable rejects requests from clients speaking another protocol
+// version.
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2".
+func parseUploadMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		value := ""
+		if len(parts) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		meta[parts[0]] = value
+	}
+	return meta
+}
+
+func (h *FileHandler) TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	if h.tusMaxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(h.tusMaxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *FileHandler) TusCreate(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	userID, err := uuid.Parse(c.MustGet("userID").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	// 1. Validate the declared length
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length header"})
+		return
+	}
+	if length == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File cannot be empty"})
+		return
+	}
+	if h.tusMaxSize > 0 && length > h.tusMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload exceeds maximum size"})
+		return
+	}
+
+	// 2. Read filename and type from metadata
+	rawMeta := c.GetHeader("Upload-Metadata")
+	meta := parseUploadMetadata(rawMeta)
+	name := meta["filename"]
+	if name == "" {
+		name = "upload"
+	}
+	mimeType := meta["filetype"]
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	// Refuse disallowed extensions before any content is sent; the
+	// content itself is checked when the upload completes
+	rules, err := h.uploadRules(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	if rejectedUpload(c, rules.CheckExtension(name)) {
+		return
+	}
+	folderID, ownerID, ok := h.resolveTargetFolder(c, meta["folder_id"], uuid.Nil)
+	if !ok {
+		return
+	}
+	if !folderID.Valid {
+		ownerID = userID
+	}
+
+	// Refuse uploads that can't fit before any content is sent; storeFile
+	// checks again once the upload completes
+	if err := h.checkQuota(c.Request.Context(), ownerID, length, 1); err != nil {
+		if !quotaExceeded(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		}
+		return
+	}
+
+	// 3. Create the staging file and tracking row
+	uploadID := uuid.New()
+	staging, err := os.Create(h.stagingPath(uploadID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	staging.Close()
+
+	expiresAt := time.Now().Add(h.tusExpiration)
+	_, err = h.db.Exec(`
+		INSERT INTO tus_uploads (
+			id, user_id, upload_length, original_name, mime_type, metadata, expires_at, folder_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		uploadID, userID, length, filepath.Base(name), mimeType,
+		sql.NullString{String: rawMeta, Valid: rawMeta != ""}, expiresAt, folderID,
+	)
+	if err != nil {
+		os.Remove(h.stagingPath(uploadID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	c.Header("Location", "/uploads/"+uploadID.String())
+	c.Header("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+func (h *FileHandler) TusHead(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	var upload tusUpload
+	err := h.db.Get(&upload, `
+		SELECT id, user_id, upload_length, upload_offset, original_name, mime_type,
+		       metadata, file_id, folder_id, expires_at, completed_at
+		FROM tus_uploads
+		WHERE id = $1 AND user_id = $2`, c.Param("upload_id"), c.GetString("userID"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if !upload.CompletedAt.Valid && time.Now().After(upload.ExpiresAt) {
+		c.Status(http.StatusGone)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.UploadOffset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.UploadLength, 10))
+	if upload.Metadata.Valid {
+		c.Header("Upload-Metadata", upload.Metadata.String)
+	}
+	if upload.FileID.Valid {
+		c.Header("X-File-ID", upload.FileID.UUID.String())
+	} else {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Status(http.StatusOK)
+}
+
+// errFolderAccessRevoked is returned when the uploader lost edit access to
+// the upload's folder before it completed.
+var errFolderAccessRevoked = errors.New("folder access revoked")
+
+// lockTusUpload takes this instance's lock on an upload so concurrent
+// PATCHes and DELETEs cannot interleave, without holding a transaction
+// open while the body streams. The staging file is local, so no other
+// instance can be working on it. It reports false if the upload is busy.
+func (h *FileHandler) lockTusUpload(uploadID uuid.UUID) (unlock func(), ok bool) {
+	v, _ := h.tusLocks.LoadOrStore(uploadID, new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func (h *FileHandler) TusPatch(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset header"})
+		return
+	}
+
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// 1. Lock the upload so concurrent PATCHes cannot interleave
+	ctx := c.Request.Context()
+	unlock, ok := h.lockTusUpload(uploadID)
+	if !ok {
+		c.Status(http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	var upload tusUpload
+	err = h.db.Get(&upload, `
+		SELECT id, user_id, upload_length, upload_offset, original_name, mime_type,
+		       metadata, file_id, folder_id, expires_at, completed_at
+		FROM tus_uploads
+		WHERE id = $1 AND user_id = $2`, uploadID, c.GetString("userID"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// 2. Validate upload state
+	if upload.CompletedAt.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Upload already completed"})
+		return
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		c.Status(http.StatusGone)
+		return
+	}
+	if offset != upload.UploadOffset {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	// 3. Append the chunk, discarding any bytes past the committed offset
+	// left behind by an earlier interrupted request
+	staging, err := os.OpenFile(h.stagingPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if err := staging.Truncate(offset); err != nil {
+		staging.Close()
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if _, err := staging.Seek(offset, io.SeekStart); err != nil {
+		staging.Close()
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	written, copyErr := io.Copy(staging, io.LimitReader(c.Request.Body, upload.UploadLength-offset))
+	if err := staging.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	// 4. Record the new offset (even for a partially received chunk). The
+	// offset check refuses the write if the upload changed meanwhile.
+	newOffset := offset + written
+	result, err := h.db.Exec(`
+		UPDATE tus_uploads SET upload_offset = $1
+		WHERE id = $2 AND upload_offset = $3 AND completed_at IS NULL`, newOffset, upload.ID, offset)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	if copyErr != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Hand the finished upload over to regular file storage
+	if newOffset == upload.UploadLength {
+		stored, err := h.finishTusUpload(ctx, &upload)
+		var rejection *contentpolicy.Rejection
+		if errors.As(err, &rejection) || errors.Is(err, errQuotaExceeded) {
+			// The content can't be accepted, so drop the upload
+			h.db.Exec(`DELETE FROM tus_uploads WHERE id = $1`, upload.ID)
+			os.Remove(h.stagingPath(upload.ID))
+			if !quotaExceeded(c, err) {
+				rejectedUpload(c, err)
+			}
+			return
+		}
+		if errors.Is(err, errFolderAccessRevoked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to save file",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		if _, err := h.db.Exec(`
+			UPDATE tus_uploads SET completed_at = NOW(), file_id = $1
+			WHERE id = $2`, stored.ID, upload.ID); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		os.Remove(h.stagingPath(upload.ID))
+		h.tusLocks.Delete(upload.ID)
+
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		c.Header("X-File-ID", stored.ID.String())
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *FileHandler) finishTusUpload(ctx context.Context, upload *tusUpload) (*storedFile, error) {
+	staging, err := os.Open(h.stagingPath(upload.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer staging.Close()
+
+	// The file belongs to the owner of its folder, which may have moved
+	// since the upload was created. The uploader must still be allowed to
+	// edit it; a deleted folder sends the file to their own top level.
+	ownerID := upload.UserID
+	if upload.FolderID.Valid {
+		access, err := h.getFolderAccess(upload.FolderID.UUID.String(), upload.UserID.String())
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			upload.FolderID = uuid.NullUUID{}
+		case err != nil:
+			return nil, err
+		case !access.allows(accessEdit):
+			return nil, errFolderAccessRevoked
+		default:
+			ownerID = access.OwnerID
+		}
+	}
+
+	// The declared filetype is only a hint; store what the content is
+	mimeType, err := h.inspectUpload(ctx, upload.UserID, upload.OriginalName, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.storeFile(ctx, ownerID, upload.FolderID, upload.OriginalName, mimeType, upload.UploadLength, staging)
+}
+
+func (h *FileHandler) TusDelete(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// Wait for no PATCH: removing the staging file under one would lose
+	// its write
+	unlock, ok := h.lockTusUpload(uploadID)
+	if !ok {
+		c.Status(http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	err = h.db.Get(&uploadID, `
+		DELETE FROM tus_uploads
+		WHERE id = $1 AND user_id = $2
+		RETURNING id`, uploadID, c.GetString("userID"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	os.Remove(h.stagingPath(uploadID))
+	h.tusLocks.Delete(uploadID)
+	c.Status(http.StatusNoContent)
+}
+
+// PurgeExpiredUploads removes expired unfinished uploads together with
+// their staged data, and forgets completed uploads past their expiry.
+func (h *FileHandler) PurgeExpiredUploads(ctx context.Context) error {
+	var expired []struct {
+		ID        uuid.UUID    `db:"id"`
+		Completed sql.NullTime `db:"completed_at"`
+	}
+	err := h.db.SelectContext(ctx, &expired, `
+		DELETE FROM tus_uploads
+		WHERE expires_at < NOW()
+		RETURNING id, completed_at`)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range expired {
+		if !upload.Completed.Valid {
+			os.Remove(h.stagingPath(upload.ID))
+		}
+		h.tusLocks.Delete(upload.ID)
+	}
+	return nil
+}
+
+// RunUploadExpiry calls PurgeExpiredUploads every interval until ctx is done.
+func (h *FileHandler) RunUploadExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.PurgeExpiredUploads(ctx); err != nil {
+				log.Printf("Failed to purge expired uploads: %v", err)
+			}
+		}
+	}
+}
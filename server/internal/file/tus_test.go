@@ -0,0 +1,56 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YogendrasinghRathod/server/internal/sqltest"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestTusUploadLocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fakeDB := sqltest.Open(t)
+	h := &FileHandler{db: db}
+	uploadID := uuid.New()
+
+	unlock, ok := h.lockTusUpload(uploadID)
+	if !ok {
+		t.Fatal("could not lock a fresh upload")
+	}
+
+	requests := []*http.Request{
+		httptest.NewRequest("PATCH", "/uploads/"+uploadID.String(), strings.NewReader("data")),
+		httptest.NewRequest("DELETE", "/uploads/"+uploadID.String(), nil),
+	}
+	for _, req := range requests {
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		c.Params = gin.Params{{Key: "upload_id", Value: uploadID.String()}}
+		if req.Method == "PATCH" {
+			h.TusPatch(c)
+		} else {
+			h.TusDelete(c)
+		}
+		if status := c.Writer.Status(); status != http.StatusLocked {
+			t.Errorf("%s while locked: status = %d, want 423", req.Method, status)
+		}
+	}
+	if len(fakeDB.Ran("tus_uploads")) != 0 {
+		t.Error("a locked upload was read or changed")
+	}
+
+	unlock()
+	if unlock, ok := h.lockTusUpload(uploadID); !ok {
+		t.Error("upload still locked after unlock")
+	} else {
+		unlock()
+	}
+}
@@ -0,0 +1,445 @@
+package file
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type fileVersion struct {
+	Version     int            `json:"version" db:"version"`
+	Size        int64          `json:"size" db:"size"`
+	Checksum    *string        `json:"checksum" db:"checksum"`
+	MimeType    sql.NullString `json:"-" db:"mime_type"`
+	StoragePath string         `json:"-" db:"storage_path"`
+	StorageType string         `json:"-" db:"storage_type"`
+	CreatedBy   uuid.NullUUID  `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	IsCurrent   bool           `json:"is_current" db:"is_current"`
+	ScanStatus  string         `json:"scan_status" db:"scan_status"`
+}
+
+// versionedFile is the slice of a files row the version endpoints need.
+type versionedFile struct {
+	ID             uuid.UUID `db:"id"`
+	UserID         uuid.UUID `db:"user_id"`
+	Name           string    `db:"name"`
+	OriginalName   string    `db:"original_name"`
+	MimeType       string    `db:"mime_type"`
+	CurrentVersion int       `db:"current_version"`
+}
+
+// getVersionedFile loads the :file_id route parameter after checking the
+// caller has the given access level, writing the error response otherwise.
+func (h *FileHandler) getVersionedFile(c *gin.Context, level accessLevel) (*versionedFile, bool) {
+	access, ok := h.authorizeFile(c, level)
+	if !ok {
+		return nil, false
+	}
+
+	var file versionedFile
+	err := h.db.Get(&file, `
+		SELECT id, user_id, name, original_name, mime_type, current_version
+		FROM files
+		WHERE id = $1`, access.FileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, false
+	}
+	return &file, true
+}
+
+// UploadVersion stores a re-upload of an existing file as its new current
+// version.
+func (h *FileHandler) UploadVersion(c *gin.Context) {
+	// 1. Get user ID from auth middleware
+	userID, err := uuid.Parse(c.MustGet("userID").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	// 2. Check the caller may edit the file
+	file, ok := h.getVersionedFile(c, accessEdit)
+	if !ok {
+		return
+	}
+
+	// 3. Get uploaded file
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	if upload.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File cannot be empty"})
+		return
+	}
+
+	src, err := upload.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	mimeType, err := h.inspectUpload(c.Request.Context(), userID, upload.Filename, src)
+	if rejectedUpload(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	// 4. Write contents, then record the version
+	ctx := c.Request.Context()
+	if err := h.checkQuota(ctx, file.UserID, upload.Size, 0); err != nil {
+		if !quotaExceeded(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check storage quota"})
+		}
+		return
+	}
+
+	obj, err := h.putObject(ctx, upload.Filename, mimeType, upload.Size, src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save file",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	version, err := h.addVersion(ctx, file.ID, userID, obj, mimeType)
+	if err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		if quotaExceeded(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file version"})
+		return
+	}
+
+	h.pruneVersions(ctx, file.ID, file.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":  file.ID,
+		"version":  version,
+		"size":     upload.Size,
+		"checksum": obj.Checksum,
+		"message":  "New version uploaded successfully",
+	})
+}
+
+// addVersion appends a version pointing at an already stored object and
+// makes it the file's current content. The new version row takes over the
+// reference held on obj.
+func (h *FileHandler) addVersion(ctx context.Context, fileID, userID uuid.UUID, obj *storedObject, mimeType string) (int, error) {
+	tx, err := h.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the file row so concurrent uploads get distinct version numbers
+	var ownerID string
+	if err := tx.Get(&ownerID, `SELECT user_id FROM files WHERE id = $1 FOR UPDATE`, fileID); err != nil {
+		return 0, err
+	}
+	owner, err := uuid.Parse(ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := lockUser(ctx, tx, owner); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = tx.Get(&version, `
+		SELECT COALESCE(MAX(version), 0) + 1 FROM file_versions WHERE file_id = $1`, fileID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO file_versions (
+			file_id, version, storage_path, storage_type, mime_type, size, checksum, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		fileID, version, obj.StoragePath, obj.StorageType, mimeType, obj.Size, nullString(obj.Checksum), userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE files
+		SET name = $1, storage_path = $2, storage_type = $3, mime_type = $4,
+		    size = $5, checksum = $6, current_version = $7, updated_at = NOW(),
+		    scan_status = 'pending', scan_signature = NULL, scanned_at = NULL,
+		    scan_attempts = 0, scan_retry_at = NULL,
+		    scan_reviewed_by = NULL, scan_reviewed_at = NULL
+		WHERE id = $8`,
+		obj.Name, obj.StoragePath, obj.StorageType, mimeType, obj.Size, nullString(obj.Checksum), version, fileID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// The file's owner is charged for the new version
+	if err := enforceQuota(ctx, tx, owner); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	h.invalidateFileCache(ctx, ownerID)
+	h.invalidateShareCache(ctx, fileID.String())
+	go h.indexContent(fileID)
+	go h.generateThumbnails(fileID)
+	go h.scanFile(fileID)
+	return version, nil
+}
+
+// versionRetentionFor returns how many versions a user keeps per file.
+func (h *FileHandler) versionRetentionFor(ctx context.Context, userID uuid.UUID) int {
+	var retention sql.NullInt64
+	err := h.db.GetContext(ctx, &retention, `SELECT version_retention FROM users WHERE id = $1`, userID)
+	if err != nil || !retention.Valid {
+		return h.versionRetention
+	}
+	return int(retention.Int64)
+}
+
+// pruneVersions deletes the oldest non-current versions beyond the
+// owner's retention count.
+func (h *FileHandler) pruneVersions(ctx context.Context, fileID, ownerID uuid.UUID) {
+	var stale []fileVersion
+	err := h.db.SelectContext(ctx, &stale, `
+		SELECT v.version, v.storage_path, v.storage_type
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE v.file_id = $1 AND v.version <> f.current_version
+		ORDER BY v.version DESC
+		OFFSET $2`, fileID, h.versionRetentionFor(ctx, ownerID)-1)
+	if err != nil {
+		log.Printf("Failed to list versions to prune for %s: %v", fileID, err)
+		return
+	}
+
+	for _, v := range stale {
+		if err := h.deleteVersion(ctx, fileID, v); err != nil {
+			log.Printf("Failed to prune version %d of %s: %v", v.Version, fileID, err)
+		}
+	}
+}
+
+func (h *FileHandler) deleteVersion(ctx context.Context, fileID uuid.UUID, v fileVersion) error {
+	_, err := h.db.ExecContext(ctx, `
+		DELETE FROM file_versions WHERE file_id = $1 AND version = $2`, fileID, v.Version)
+	if err != nil {
+		return err
+	}
+	return h.releaseObject(ctx, v.StorageType, v.StoragePath)
+}
+
+func (h *FileHandler) getVersion(fileID uuid.UUID, versionParam string) (*fileVersion, error) {
+	versionNum, err := strconv.Atoi(versionParam)
+	if err != nil {
+		return nil, err
+	}
+
+	var v fileVersion
+	err = h.db.Get(&v, `
+		SELECT v.version, v.size, v.checksum, v.mime_type, v.storage_path, v.storage_type,
+		       v.created_by, v.created_at, v.version = f.current_version AS is_current,
+		       v.scan_status
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE v.file_id = $1 AND v.version = $2`, fileID, versionNum)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (h *FileHandler) ListVersions(c *gin.Context) {
+	file, ok := h.getVersionedFile(c, accessView)
+	if !ok {
+		return
+	}
+
+	versions := []fileVersion{}
+	err := h.db.Select(&versions, `
+		SELECT v.version, v.size, v.checksum, v.mime_type, v.storage_path, v.storage_type,
+		       v.created_by, v.created_at, v.version = f.current_version AS is_current,
+		       v.scan_status
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE v.file_id = $1
+		ORDER BY v.version DESC`, file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":         file.ID,
+		"current_version": file.CurrentVersion,
+		"versions":        versions,
+	})
+}
+
+func (h *FileHandler) DownloadVersion(c *gin.Context) {
+	file, ok := h.getVersionedFile(c, accessView)
+	if !ok {
+		return
+	}
+
+	v, err := h.getVersion(file.ID, c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	// Each version is only served once its own content has scanned clean
+	if scanBlocked(c, v.ScanStatus) {
+		return
+	}
+
+	obj := &servedObject{
+		StorageType: v.StorageType,
+		StoragePath: v.StoragePath,
+		Name:        file.Name,
+		MimeType:    file.MimeType,
+		Size:        v.Size,
+		ModTime:     v.CreatedAt,
+	}
+	if v.MimeType.Valid {
+		obj.MimeType = v.MimeType.String
+	}
+	if v.Checksum != nil {
+		obj.Checksum = *v.Checksum
+	}
+	h.serveObject(c, obj, "attachment")
+}
+
+// RestoreVersion makes an older version current again by appending a new
+// version that shares its stored object, so history is never rewritten.
+func (h *FileHandler) RestoreVersion(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("userID").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	file, ok := h.getVersionedFile(c, accessEdit)
+	if !ok {
+		return
+	}
+
+	v, err := h.getVersion(file.ID, c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	if v.IsCurrent {
+		c.JSON(http.StatusConflict, gin.H{"error": "Version is already current"})
+		return
+	}
+
+	mimeType := file.MimeType
+	if v.MimeType.Valid {
+		mimeType = v.MimeType.String
+	}
+
+	// The restored version shares the old version's object
+	ctx := c.Request.Context()
+	obj := &storedObject{
+		Name:        file.Name,
+		StoragePath: v.StoragePath,
+		StorageType: v.StorageType,
+		Size:        v.Size,
+	}
+	if v.Checksum != nil {
+		obj.Checksum = *v.Checksum
+	}
+	if err := h.retainObject(ctx, obj.StorageType, obj.StoragePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		return
+	}
+
+	version, err := h.addVersion(ctx, file.ID, userID, obj, mimeType)
+	if err != nil {
+		h.releaseObject(ctx, obj.StorageType, obj.StoragePath)
+		if quotaExceeded(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		return
+	}
+
+	h.pruneVersions(ctx, file.ID, file.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":       file.ID,
+		"version":       version,
+		"restored_from": v.Version,
+		"message":       "Version restored successfully",
+	})
+}
+
+func (h *FileHandler) DeleteVersion(c *gin.Context) {
+	file, ok := h.getVersionedFile(c, accessEdit)
+	if !ok {
+		return
+	}
+
+	v, err := h.getVersion(file.ID, c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	if v.IsCurrent {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot delete the current version"})
+		return
+	}
+
+	if err := h.deleteVersion(c.Request.Context(), file.ID, *v); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Version deleted successfully"})
+}
+
+// SetVersionRetention changes how many versions the caller keeps per file.
+// A null retention falls back to the deployment default.
+func (h *FileHandler) SetVersionRetention(c *gin.Context) {
+	var req struct {
+		Retention *int `json:"retention" binding:"omitempty,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec(`UPDATE users SET version_retention = $1 WHERE id = $2`, req.Retention, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update version retention"})
+		return
+	}
+
+	retention := h.versionRetention
+	if req.Retention != nil {
+		retention = *req.Retention
+	}
+	c.JSON(http.StatusOK, gin.H{"version_retention": retention})
+}
@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Message is a plain-text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Log writes messages, links and all, to the server log instead of
+// sending them. It is for development only and must be asked for with
+// MAILER=log.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg *Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Disabled drops every message, logging only that it wasn't sent. It is
+// used when no SMTP server is configured.
+type Disabled struct{}
+
+func (Disabled) Send(ctx context.Context, msg *Message) error {
+	log.Printf("mail to %s not sent (no SMTP_HOST): %s", msg.To, msg.Subject)
+	return nil
+}
+
+// FromEnv returns Log for MAILER=log, otherwise an SMTP mailer for
+// SMTP_HOST, or Disabled when it is unset. SMTP_PORT defaults to 587;
+// SMTP_USERNAME and SMTP_PASSWORD enable PLAIN auth; SMTP_FROM is the
+// sender address; SMTP_TIMEOUT_SECONDS bounds a delivery (default 30).
+func FromEnv() (Mailer, error) {
+	switch kind := os.Getenv("MAILER"); kind {
+	case "log":
+		return Log{}, nil
+	case "", "smtp":
+	default:
+		return nil, fmt.Errorf("MAILER must be smtp or log, not %q", kind)
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return Disabled{}, nil
+	}
+
+	port := 587
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 {
+			return nil, errors.New("SMTP_PORT must be a positive integer")
+		}
+		port = p
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return nil, errors.New("SMTP_FROM must be set when SMTP_HOST is")
+	}
+
+	timeout := 30 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("SMTP_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Second
+	}
+	return NewSMTP(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from, timeout)
+}
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envelope is what the sink received for one message.
+type envelope struct {
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpSink accepts one SMTP session, without STARTTLS, and records it.
+// Message data is stored with LF line endings.
+func smtpSink(t *testing.T) (host string, port int, received <-chan envelope) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	got := make(chan envelope, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		var env envelope
+
+		tc.PrintfLine("220 sink ready")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				tc.PrintfLine("250-sink\r\n250 AUTH PLAIN")
+			case "AUTH":
+				env.auth = strings.TrimPrefix(arg, "PLAIN ")
+				tc.PrintfLine("235 ok")
+			case "MAIL":
+				env.from = arg
+				tc.PrintfLine("250 ok")
+			case "RCPT":
+				env.to = append(env.to, arg)
+				tc.PrintfLine("250 ok")
+			case "DATA":
+				tc.PrintfLine("354 go ahead")
+				if env.data, err = tc.ReadDotBytes(); err != nil {
+					return
+				}
+				tc.PrintfLine("250 queued")
+			case "QUIT":
+				tc.PrintfLine("221 bye")
+				got <- env
+				return
+			default:
+				tc.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, got
+}
+
+func TestSMTPSend(t *testing.T) {
+	host, port, received := smtpSink(t)
+	s, err := NewSMTP(host, port, "user", "pass", "Fileshare <noreply@example.com>", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A non-ASCII subject, a line longer than 76 characters, an "=" and a
+	// line starting with "." all need encoding or escaping
+	body := "Hello Zoë,\n\n" + strings.Repeat("long line ", 12) + "\na=b\n.dot\n"
+	err = s.Send(context.Background(), &Message{To: "Zoë <zoe@example.org>", Subject: "Grüße", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env envelope
+	select {
+	case env = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("sink received no message")
+	}
+
+	if want := base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass")); env.auth != want {
+		t.Errorf("AUTH = %q, want %q", env.auth, want)
+	}
+	if env.from != "FROM:<noreply@example.com>" {
+		t.Errorf("MAIL %s", env.from)
+	}
+	if len(env.to) != 1 || env.to[0] != "TO:<zoe@example.org>" {
+		t.Errorf("RCPT %v", env.to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(env.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{
+		"From":                      `"Fileshare" <noreply@example.com>`,
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+	for name, want := range headers {
+		if got := msg.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Name != "Zoë" || to[0].Address != "zoe@example.org" {
+		t.Errorf("To = %q", msg.Header.Get("To"))
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != "Grüße" {
+		t.Errorf("Subject = %q", msg.Header.Get("Subject"))
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+
+	// ReadDotBytes has already turned the wire's CRLFs into LFs
+	raw, _ := io.ReadAll(msg.Body)
+	for _, line := range strings.Split(string(raw), "\n") {
+		if len(line) > 76 {
+			t.Errorf("body line longer than 76 characters: %q", line)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body {
+		t.Errorf("body = %q, want %q", decoded, body)
+	}
+}
@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Templates hold a subject line and a body separated by a blank line.
+var templates = template.Must(template.New("").Parse(`
+{{define "verify_email"}}Verify your email address
+
+Welcome! Confirm that {{.Email}} is your address by opening this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you didn't create an account, you
+can ignore this email.
+{{end}}
+
+{{define "reset_password"}}Reset your password
+
+Someone asked to reset the password for {{.Email}}. To choose a new
+password, open this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and works once. If you didn't ask for
+this, you can ignore this email; your password has not been changed.
+{{end}}
+
+{{define "password_changed"}}Your password was changed
+
+The password for {{.Email}} was just reset and all of your sessions were
+signed out. If this wasn't you, reset your password again right away.
+{{end}}
+
+{{define "account_locked"}}Your account was locked
+
+There were too many failed sign-in attempts for {{.Email}}, so signing in
+is blocked for {{.LockedFor}}. If it was you, open this link to unlock it
+now:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If it wasn't you, someone may be
+guessing your password; consider choosing a stronger one.
+{{end}}
+
+{{define "account_exists"}}You already have an account
+
+Someone tried to create an account with {{.Email}}, but there is one
+already. If it was you, sign in, or reset your password here:
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email.
+{{end}}
+`))
+
+// Render builds a message to the given recipient from a named template.
+func Render(name, to string, data interface{}) (*Message, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("mailer: %w", err)
+	}
+	subject, body, ok := strings.Cut(buf.String(), "\n\n")
+	if !ok {
+		return nil, fmt.Errorf("mailer: template %q has no body", name)
+	}
+	return &Message{To: to, Subject: strings.TrimSpace(subject), Body: strings.TrimSpace(body) + "\n"}, nil
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]string{
+		"Email":     "ada@example.com",
+		"Link":      "https://app.example.com/verify-email?token=abc",
+		"ExpiresIn": "24 hours",
+		"LockedFor": "30 minutes",
+	}
+	tests := []struct {
+		name    string
+		subject string
+		hasLink bool
+	}{
+		{"verify_email", "Verify your email address", true},
+		{"reset_password", "Reset your password", true},
+		{"password_changed", "Your password was changed", false},
+		{"account_locked", "Your account was locked", true},
+		{"account_exists", "You already have an account", true},
+	}
+	for _, tt := range tests {
+		msg, err := Render(tt.name, "ada@example.com", data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if msg.To != "ada@example.com" || msg.Subject != tt.subject {
+			t.Errorf("%s: To = %q, Subject = %q", tt.name, msg.To, msg.Subject)
+		}
+		if !strings.Contains(msg.Body, data["Email"]) {
+			t.Errorf("%s: body doesn't mention the address:\n%s", tt.name, msg.Body)
+		}
+		if strings.Contains(msg.Body, data["Link"]) != tt.hasLink {
+			t.Errorf("%s: body link present = %v, want %v", tt.name, !tt.hasLink, tt.hasLink)
+		}
+		if strings.Contains(msg.Body, "<no value>") || !strings.HasSuffix(msg.Body, "\n") || strings.HasPrefix(msg.Body, "\n") {
+			t.Errorf("%s: malformed body:\n%q", tt.name, msg.Body)
+		}
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("no_such_template", "ada@example.com", nil); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
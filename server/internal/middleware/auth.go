@@ -4,23 +4,22 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	"fmt"
-	
 
+	"github.com/YogendrasinghRathod/server/internal/signing"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jmoiron/sqlx"
 )
 
 type AuthMiddleware struct {
-	db        *sqlx.DB
-	jwtSecret []byte
+	db   *sqlx.DB
+	keys *signing.KeySet
 }
 
-func NewAuthMiddleware(db *sqlx.DB, jwtSecret []byte) *AuthMiddleware {
+func NewAuthMiddleware(db *sqlx.DB, keys *signing.KeySet) *AuthMiddleware {
 	return &AuthMiddleware{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:   db,
+		keys: keys,
 	}
 }
 
@@ -34,18 +33,8 @@ func (m *AuthMiddleware) Handler() gin.HandlerFunc {
 			return
 		}
 
-		// Parse token
-		
-// Copy
-token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-    // 1. Verify the signing method
-    if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-        return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-    }
-    
-    // 2. Return the secret key
-    return m.jwtSecret, nil
-})
+		// Parse token; the key set checks the kid and signing method
+		token, err := m.keys.Parse(tokenString)
 
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
@@ -74,7 +63,7 @@ token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error)
 
 		var expiresAt time.Time
 		err = m.db.QueryRow(
-			"SELECT expires_at FROM auth_tokens WHERE token = $1 AND user_id = $2",
+			"SELECT expires_at FROM auth_tokens WHERE token = $1 AND user_id = $2 AND revoked_at IS NULL",
 			tokenString, userID,
 		).Scan(&expiresAt)
 
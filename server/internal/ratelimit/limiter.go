@@ -0,0 +1,177 @@
+// Package ratelimit limits request rates and download bandwidth with token
+// buckets kept in Redis, so every instance shares the same counts.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Policy allows Limit tokens (requests, or bytes for bandwidth) per Period,
+// with up to Burst taken at once.
+type Policy struct {
+	Name   string
+	Limit  int64
+	Period time.Duration
+	Burst  int64
+}
+
+// Built-in policies. RATE_LIMIT_<NAME> (e.g. RATE_LIMIT_LOGIN=5/1m)
+// overrides one; "off" disables it.
+var defaults = []Policy{
+	// Sign-in attempts and token refreshes, per IP
+	{Name: "login", Limit: 20, Period: time.Minute},
+	// Registration, verification, password reset and unlock mail, per IP
+	{Name: "account", Limit: 10, Period: time.Hour},
+	// Shared link downloads and thumbnails, per IP
+	{Name: "share", Limit: 60, Period: time.Minute},
+	// New uploads, per user or API key
+	{Name: "upload", Limit: 60, Period: time.Minute},
+	// Every authenticated request, per user or API key
+	{Name: "api", Limit: 600, Period: time.Minute},
+	// Bytes per period across an IP's shared link downloads; off unless
+	// set, e.g. RATE_LIMIT_SHARE_BANDWIDTH=1048576/1s
+	{Name: "share_bandwidth"},
+}
+
+// Result is the outcome of taking tokens from a bucket.
+type Result struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is how long until the request would be allowed
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Limiter applies named policies. A policy that is unknown or turned off
+// lets everything through.
+type Limiter struct {
+	client   *redis.Client
+	policies map[string]Policy
+}
+
+// FromEnv builds a Limiter from the built-in policies and any
+// RATE_LIMIT_<NAME> overrides.
+func FromEnv(client *redis.Client) (*Limiter, error) {
+	l := &Limiter{client: client, policies: map[string]Policy{}}
+	for _, p := range defaults {
+		env := "RATE_LIMIT_" + strings.ToUpper(p.Name)
+		if v := os.Getenv(env); v != "" {
+			var err error
+			if p, err = ParsePolicy(p.Name, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+		if p.Limit > 0 {
+			if p.Burst <= 0 {
+				p.Burst = p.Limit
+			}
+			l.policies[p.Name] = p
+		}
+	}
+	return l, nil
+}
+
+// ParsePolicy reads "<limit>/<period>", e.g. "100/1m", "5/h" or
+// "1048576/1s", or "off".
+func ParsePolicy(name, s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return Policy{Name: name}, nil
+	}
+	limitStr, periodStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("invalid rate %q, want <limit>/<period>", s)
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(limitStr), 10, 64)
+	if err != nil || limit <= 0 {
+		return Policy{}, fmt.Errorf("invalid limit %q", limitStr)
+	}
+	periodStr = strings.TrimSpace(periodStr)
+	if periodStr != "" && strings.IndexAny(periodStr[:1], "0123456789") < 0 {
+		periodStr = "1" + periodStr
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return Policy{}, fmt.Errorf("invalid period %q", periodStr)
+	}
+	return Policy{Name: name, Limit: limit, Period: period, Burst: limit}, nil
+}
+
+// gcra is a token bucket kept as a single "theoretical arrival time": the
+// moment the bucket would be full again. Redis's clock is used so every
+// instance agrees. Times are in milliseconds.
+var gcra = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + cost * interval
+local allow_at = new_tat - burst * interval
+if allow_at > now then
+	local remaining = math.max(0, math.floor((now - (tat - burst * interval)) / interval))
+	return {0, remaining, tostring(allow_at - now), tostring(tat - now)}
+end
+
+redis.call("SET", KEYS[1], tostring(new_tat), "PX", math.ceil(new_tat - now))
+return {1, math.floor((now - allow_at) / interval), "0", tostring(new_tat - now)}
+`)
+
+// Allow takes cost tokens from key's bucket under the named policy. A
+// cost larger than the policy's burst is never allowed.
+func (l *Limiter) Allow(ctx context.Context, name, key string, cost int64) (*Result, error) {
+	p, ok := l.policies[name]
+	if !ok {
+		return &Result{Allowed: true}, nil
+	}
+
+	interval := float64(p.Period) / float64(time.Millisecond) / float64(p.Limit)
+	vals, err := gcra.Run(ctx, l.client, []string{"rate_limit:" + name + ":" + key},
+		strconv.FormatFloat(interval, 'f', -1, 64), p.Burst, cost).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != 4 {
+		return nil, fmt.Errorf("ratelimit: unexpected reply %v", vals)
+	}
+
+	allowed, _ := vals[0].(int64)
+	remaining, _ := vals[1].(int64)
+	retryAfter, err := milliseconds(vals[2])
+	if err != nil {
+		return nil, err
+	}
+	resetAfter, err := milliseconds(vals[3])
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Allowed:    allowed == 1,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+func milliseconds(v interface{}) (time.Duration, error) {
+	s, _ := v.(string)
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ratelimit: unexpected duration %v", v)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in     string
+		limit  int64
+		period time.Duration
+		ok     bool
+	}{
+		{"100/1m", 100, time.Minute, true},
+		{"5/h", 5, time.Hour, true},
+		{"1048576/1s", 1048576, time.Second, true},
+		{" 10 / 30s ", 10, 30 * time.Second, true},
+		{"3/1h30m", 3, 90 * time.Minute, true},
+		{"off", 0, 0, true},
+		{"100", 0, 0, false},
+		{"0/1m", 0, 0, false},
+		{"-1/1m", 0, 0, false},
+		{"x/1m", 0, 0, false},
+		{"10/", 0, 0, false},
+		{"10/0s", 0, 0, false},
+		{"10/-1m", 0, 0, false},
+		{"10/fortnight", 0, 0, false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePolicy("test", tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParsePolicy(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if p.Name != "test" || p.Limit != tt.limit || p.Period != tt.period || p.Burst != tt.limit {
+			t.Errorf("ParsePolicy(%q) = %+v, want limit %d per %v", tt.in, p, tt.limit, tt.period)
+		}
+	}
+}
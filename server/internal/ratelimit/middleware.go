@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc picks the bucket a request is counted in.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByClient counts authenticated requests per API key or user, and anything
+// else per IP. It must run after AuthMiddleware.
+func ByClient(c *gin.Context) string {
+	if id := c.GetString("apiKeyID"); id != "" {
+		return "key:" + id
+	}
+	if id := c.GetString("userID"); id != "" {
+		return "user:" + id
+	}
+	return ByIP(c)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Limit rejects requests over the named policy with 429 and Retry-After,
+// and reports the bucket in RateLimit-* headers. When several policies
+// apply the headers describe the one closest to its limit. If Redis is
+// unreachable requests are let through.
+func (l *Limiter) Limit(name string, key KeyFunc) gin.HandlerFunc {
+	p, ok := l.policies[name]
+	if !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		res, err := l.Allow(c.Request.Context(), name, key(c), 1)
+		if err != nil {
+			log.Printf("rate limit %s: %v", name, err)
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if prev, err := strconv.ParseInt(h.Get("RateLimit-Remaining"), 10, 64); err != nil || res.Remaining <= prev {
+			h.Set("RateLimit-Limit", strconv.FormatInt(p.Burst, 10))
+			h.Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			h.Set("RateLimit-Reset", seconds(res.ResetAfter))
+			h.Set("RateLimit-Policy", strconv.FormatInt(p.Limit, 10)+";w="+seconds(p.Period))
+		}
+
+		if !res.Allowed {
+			h.Set("Retry-After", seconds(res.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "rate limit exceeded",
+				"retry_after": int(math.Ceil(res.RetryAfter.Seconds())),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Bandwidth paces the response body to the named policy's bytes per
+// period, shared by all of a key's concurrent downloads.
+func (l *Limiter) Bandwidth(name string, key KeyFunc) gin.HandlerFunc {
+	p, ok := l.policies[name]
+	if !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	// Take tokens in chunks of about a tenth of the burst, so a download
+	// makes a handful of Redis calls per period
+	chunk := p.Burst / 10
+	if chunk < 4<<10 {
+		chunk = 4 << 10
+	}
+	if chunk > 256<<10 {
+		chunk = 256 << 10
+	}
+	if chunk > p.Burst {
+		chunk = p.Burst
+	}
+
+	return func(c *gin.Context) {
+		c.Writer = &throttledWriter{
+			ResponseWriter: c.Writer,
+			ctx:            c.Request.Context(),
+			limiter:        l,
+			name:           name,
+			key:            key(c),
+			chunk:          int(chunk),
+		}
+		c.Next()
+	}
+}
+
+type throttledWriter struct {
+	gin.ResponseWriter
+	ctx     context.Context
+	limiter *Limiter
+	name    string
+	key     string
+	chunk   int
+	// unlimited is set once Redis fails, for the rest of the response
+	unlimited bool
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 && !w.unlimited {
+		n := len(b)
+		if n > w.chunk {
+			n = w.chunk
+		}
+		if err := w.wait(int64(n)); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	if len(b) > 0 {
+		m, err := w.ResponseWriter.Write(b)
+		return written + m, err
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// wait blocks until n bytes may be sent.
+func (w *throttledWriter) wait(n int64) error {
+	for {
+		res, err := w.limiter.Allow(w.ctx, w.name, w.key, n)
+		if err != nil {
+			log.Printf("rate limit %s: %v", w.name, err)
+			w.unlimited = true
+			return nil
+		}
+		if res.Allowed {
+			return nil
+		}
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		case <-time.After(res.RetryAfter):
+		}
+	}
+}
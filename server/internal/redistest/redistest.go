@@ -0,0 +1,222 @@
+// Package redistest runs an in-memory server speaking enough of the Redis
+// protocol for tests: strings with expiry, counters and GETDEL. Scripts
+// and anything else are answered with an error.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Server is an in-memory Redis. Its keys can be inspected and set directly.
+type Server struct {
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+// NewClient starts a server for the test and returns a client for it.
+func NewClient(t testing.TB) (*redis.Client, *Server) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{values: map[string]string{}, expires: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2, DisableIdentity: true})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return client, s
+}
+
+// Get returns a key's value.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+// Set stores a key with no expiry.
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	delete(s.expires, key)
+}
+
+func (s *Server) get(key string) (string, bool) {
+	if at, ok := s.expires[key]; ok && !time.Now().Before(at) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		reply := s.exec(args)
+		s.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("redistest: unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(v string, ok bool) string {
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+}
+
+func integer(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+func (s *Server) exec(args []string) string {
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	switch cmd := strings.ToUpper(args[0]); {
+	case cmd == "PING":
+		return "+PONG\r\n"
+	case cmd == "GET" && len(args) == 2:
+		return bulk(s.get(args[1]))
+	case cmd == "GETDEL" && len(args) == 2:
+		v, ok := s.get(args[1])
+		delete(s.values, args[1])
+		delete(s.expires, args[1])
+		return bulk(v, ok)
+	case cmd == "SET" && len(args) >= 3:
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return "-ERR syntax error\r\n"
+				}
+				n, _ := strconv.ParseInt(args[i+1], 10, 64)
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.ToUpper(args[i]) == "EX" {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			}
+		}
+		if _, exists := s.get(args[1]); nx && exists {
+			return "$-1\r\n"
+		}
+		s.values[args[1]] = args[2]
+		delete(s.expires, args[1])
+		if ttl > 0 {
+			s.expires[args[1]] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case cmd == "DEL" || cmd == "EXISTS":
+		var n int64
+		for _, key := range args[1:] {
+			if _, ok := s.get(key); ok {
+				n++
+				if cmd == "DEL" {
+					delete(s.values, key)
+					delete(s.expires, key)
+				}
+			}
+		}
+		return integer(n)
+	case cmd == "INCR" && len(args) == 2:
+		v, _ := s.get(args[1])
+		n, _ := strconv.ParseInt(v, 10, 64)
+		n++
+		s.values[args[1]] = strconv.FormatInt(n, 10)
+		return integer(n)
+	case (cmd == "EXPIRE" || cmd == "PEXPIRE") && len(args) >= 3:
+		if _, ok := s.get(args[1]); !ok {
+			return integer(0)
+		}
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		unit := time.Second
+		if cmd == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		s.expires[args[1]] = time.Now().Add(time.Duration(n) * unit)
+		return integer(1)
+	case (cmd == "TTL" || cmd == "PTTL") && len(args) == 2:
+		if _, ok := s.get(args[1]); !ok {
+			return integer(-2)
+		}
+		at, ok := s.expires[args[1]]
+		if !ok {
+			return integer(-1)
+		}
+		if cmd == "TTL" {
+			return integer(int64(time.Until(at) / time.Second))
+		}
+		return integer(time.Until(at).Milliseconds())
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is the INSTREAM chunk length; clamd's StreamMaxLength limits
+// the total, not the chunks.
+const chunkSize = 64 << 10
+
+// Clamd talks to a ClamAV daemon using the INSTREAM command.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd parses a tcp://, unix:// or bare host:port address.
+func NewClamd(addr string, timeout time.Duration) (*Clamd, error) {
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		address = strings.TrimPrefix(addr, "tcp://")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("clamd: invalid address %q", addr)
+	}
+	return &Clamd{network: network, address: address, timeout: timeout}, nil
+}
+
+// Scan streams r to clamd and parses its reply, which looks like
+// "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR".
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// clamd may stop reading and reply early (e.g. size limit exceeded),
+	// so a write error is only reported if no reply can be read
+	writeErr := c.stream(conn, r)
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		if writeErr != nil {
+			return nil, fmt.Errorf("clamd: %w", writeErr)
+		}
+		return nil, fmt.Errorf("clamd: reading reply: %w", err)
+	}
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+func (c *Clamd) stream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A zero-length chunk ends the stream
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func parseReply(reply string) (*Result, error) {
+	verdict := strings.TrimSpace(reply)
+	if i := strings.Index(verdict, ": "); i >= 0 {
+		verdict = verdict[i+2:]
+	}
+
+	switch {
+	case verdict == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return nil, &ReplyError{Reply: reply}
+	}
+}
+
+// ReplyError is a reply from clamd that is neither clean nor infected,
+// e.g. "stream: INSTREAM size limit exceeded. ERROR". The scanner did see
+// the content, so retrying won't help.
+type ReplyError struct {
+	Reply string
+}
+
+func (e *ReplyError) Error() string {
+	return "clamd: " + e.Reply
+}
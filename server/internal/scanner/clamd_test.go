@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one INSTREAM session per connection, records the
+// streamed bytes and answers with reply.
+func fakeClamd(t *testing.T, reply string) (addr string, received <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		cmd, err := r.ReadString(0)
+		if err != nil || cmd != "zINSTREAM\x00" {
+			conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			return
+		}
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+		}
+		got <- data.Bytes()
+		conn.Write([]byte(reply + "\x00"))
+	}()
+	return "tcp://" + ln.Addr().String(), got
+}
+
+func TestClamdScan(t *testing.T) {
+	// Larger than one chunk so the stream is split
+	content := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8)
+
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		replyErr  bool
+	}{
+		{"stream: OK", false, "", false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", true, "Win.Test.EICAR_HDB-1", false},
+		{"INSTREAM size limit exceeded. ERROR", false, "", true},
+	}
+	for _, tt := range tests {
+		addr, received := fakeClamd(t, tt.reply)
+		c, err := NewClamd(addr, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := c.Scan(context.Background(), bytes.NewReader(content))
+		var replyErr *ReplyError
+		if tt.replyErr {
+			if !errors.As(err, &replyErr) {
+				t.Errorf("%q: err = %v, want *ReplyError", tt.reply, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", tt.reply, err)
+		} else if res.Infected != tt.infected || res.Signature != tt.signature {
+			t.Errorf("%q: got %+v", tt.reply, res)
+		}
+
+		select {
+		case data := <-received:
+			if !bytes.Equal(data, content) {
+				t.Errorf("%q: clamd received %d bytes, want %d", tt.reply, len(data), len(content))
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%q: clamd received no stream", tt.reply)
+		}
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := NewClamd(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Scan(context.Background(), bytes.NewReader([]byte("data")))
+	var replyErr *ReplyError
+	if err == nil || errors.As(err, &replyErr) {
+		t.Errorf("err = %v, want a retryable error", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		scanner string
+		addr    string
+		want    string
+	}{
+		{"", "", "error"},
+		{"clamd", "", "error"},
+		{"none", "", "noop"},
+		{"", "tcp://127.0.0.1:3310", "clamd"},
+		{"bogus", "tcp://127.0.0.1:3310", "error"},
+	}
+	for _, tt := range tests {
+		t.Setenv("SCANNER", tt.scanner)
+		t.Setenv("CLAMD_ADDRESS", tt.addr)
+
+		s, err := FromEnv()
+		got := "error"
+		switch s.(type) {
+		case Noop:
+			got = "noop"
+		case *Clamd:
+			got = "clamd"
+		}
+		if got != tt.want {
+			t.Errorf("SCANNER=%q CLAMD_ADDRESS=%q: got %s (err %v), want %s", tt.scanner, tt.addr, got, err, tt.want)
+		}
+	}
+}
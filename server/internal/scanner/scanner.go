@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Result is the verdict for one scanned stream.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner checks content for malware. An error means no verdict could be
+// reached, not that the content is bad; a *ReplyError means the scanner
+// itself refused the content, anything else is worth retrying.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
+
+// Noop reports everything as clean. It is only used for SCANNER=none so
+// that running without malware scanning is a deliberate choice.
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	return &Result{}, nil
+}
+
+// FromEnv returns a clamd client for CLAMD_ADDRESS ("tcp://host:3310",
+// "unix:///run/clamav/clamd.ctl" or "host:port"), or Noop for
+// SCANNER=none. Anything else is an error rather than silently marking
+// every upload clean. CLAMD_TIMEOUT_SECONDS bounds a single scan
+// (default 120).
+func FromEnv() (Scanner, error) {
+	switch kind := os.Getenv("SCANNER"); kind {
+	case "none":
+		return Noop{}, nil
+	case "", "clamd":
+	default:
+		return nil, fmt.Errorf("SCANNER must be clamd or none, not %q", kind)
+	}
+
+	addr := os.Getenv("CLAMD_ADDRESS")
+	if addr == "" {
+		return nil, errors.New("CLAMD_ADDRESS is not set; set SCANNER=none to run without malware scanning")
+	}
+
+	timeout := 120 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("CLAMD_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Second
+	}
+	return NewClamd(addr, timeout)
+}
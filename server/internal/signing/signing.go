@@ -0,0 +1,438 @@
+// Package signing manages the asymmetric keys access tokens are signed
+// with. Keys live in the signing_keys table so every instance signs and
+// verifies with the same set; private keys are encrypted at rest.
+//
+// Rotation is scheduled: a new key is published in the JWKS an hour before
+// it starts signing, so verifiers that cache the JWKS pick it up in time,
+// and a replaced key stays valid for verification until every token it
+// signed has expired.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+
+	// publishAhead is how long a new key is in the JWKS before it signs
+	publishAhead = time.Hour
+	// reloadInterval bounds how often an unknown kid triggers a reload
+	reloadInterval = 10 * time.Second
+	rsaKeyBits     = 2048
+)
+
+var ErrUnknownKey = errors.New("signing: unknown key")
+
+// Key is one signing key pair.
+type Key struct {
+	ID          string
+	Algorithm   string
+	Private     crypto.Signer
+	Public      crypto.PublicKey
+	ActivatesAt time.Time
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == EdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySet is the in-memory view of signing_keys.
+type KeySet struct {
+	db          *sqlx.DB
+	cipher      cipher.AEAD
+	algorithm   string
+	rotateEvery time.Duration
+	// retainFor is how long a replaced key still verifies: the longest
+	// lifetime of a token it may have signed
+	retainFor time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]*Key
+	active   *Key
+	loadedAt time.Time
+}
+
+// New creates a key set. secret encrypts private keys at rest; it must be
+// the same on every instance.
+func New(db *sqlx.DB, secret []byte, algorithm string, rotateEvery, retainFor time.Duration) (*KeySet, error) {
+	if algorithm != RS256 && algorithm != EdDSA {
+		return nil, fmt.Errorf("signing: unsupported algorithm %q", algorithm)
+	}
+	sum := sha256.Sum256(append([]byte("signing-keys:"), secret...))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{
+		db:          db,
+		cipher:      aead,
+		algorithm:   algorithm,
+		rotateEvery: rotateEvery,
+		retainFor:   retainFor,
+		keys:        map[string]*Key{},
+	}, nil
+}
+
+// Sign signs claims with the active key and sets its kid.
+func (s *KeySet) Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	key, err := s.activeKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Parse verifies a token signed by any key in the set. Only RS256 and
+// EdDSA are accepted, and the algorithm must match the kid's key.
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, s.keyfunc, jwt.WithValidMethods([]string{RS256, EdDSA}))
+}
+
+func (s *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("signing: token has no kid")
+	}
+	key, err := s.lookup(context.Background(), kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("signing: algorithm %s does not match key %s", token.Method.Alg(), kid)
+	}
+	return key.Public, nil
+}
+
+// lookup finds a key by ID, reloading once in a while for kids minted by
+// another instance since the last load.
+func (s *KeySet) lookup(ctx context.Context, kid string) (*Key, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	stale := time.Since(s.loadedAt) > reloadInterval
+	s.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, ErrUnknownKey
+	}
+	if err := s.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (s *KeySet) activeKey(ctx context.Context) (*Key, error) {
+	s.mu.RLock()
+	key := s.active
+	s.mu.RUnlock()
+	if key != nil && key.Algorithm == s.algorithm {
+		return key, nil
+	}
+
+	// First use, or the configured algorithm changed
+	if err := s.Rotate(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.active == nil {
+		return nil, errors.New("signing: no active key")
+	}
+	return s.active, nil
+}
+
+type keyRow struct {
+	ID          string    `db:"kid"`
+	Algorithm   string    `db:"algorithm"`
+	PrivateKey  []byte    `db:"private_key"`
+	PublicKey   []byte    `db:"public_key"`
+	ActivatesAt time.Time `db:"activates_at"`
+}
+
+// Load replaces the in-memory set with the keys in the table.
+func (s *KeySet) Load(ctx context.Context) error {
+	var rows []keyRow
+	err := s.db.SelectContext(ctx, &rows, `
+		SELECT kid, algorithm, private_key, public_key, activates_at
+		FROM signing_keys
+		ORDER BY activates_at`)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*Key, len(rows))
+	var active *Key
+	now := time.Now()
+	for _, row := range rows {
+		key, err := s.decode(row)
+		if err != nil {
+			log.Printf("signing key %s: %v", row.ID, err)
+			continue
+		}
+		keys[key.ID] = key
+		if !key.ActivatesAt.After(now) && key.Algorithm == s.algorithm && key.Private != nil {
+			active = key
+		}
+	}
+
+	s.mu.Lock()
+	s.keys, s.active, s.loadedAt = keys, active, now
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *KeySet) decode(row keyRow) (*Key, error) {
+	pub, err := x509.ParsePKIXPublicKey(row.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{ID: row.ID, Algorithm: row.Algorithm, Public: pub, ActivatesAt: row.ActivatesAt}
+
+	n := s.cipher.NonceSize()
+	if len(row.PrivateKey) < n {
+		return nil, errors.New("private key too short")
+	}
+	der, err := s.cipher.Open(nil, row.PrivateKey[:n], row.PrivateKey[n:], []byte(row.ID))
+	if err != nil {
+		// Still usable for verification, e.g. after the secret changed
+		return key, nil
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if signer, ok := priv.(crypto.Signer); ok {
+		key.Private = signer
+	}
+	return key, nil
+}
+
+func (s *KeySet) generate() (*Key, []byte, []byte, error) {
+	var priv crypto.Signer
+	var err error
+	switch s.algorithm {
+	case EdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sum := sha256.Sum256(pubDER)
+	kid := base64.RawURLEncoding.EncodeToString(sum[:12])
+	nonce := make([]byte, s.cipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := s.cipher.Seal(nonce, nonce, privDER, []byte(kid))
+
+	return &Key{ID: kid, Algorithm: s.algorithm, Private: priv, Public: priv.Public()}, pubDER, sealed, nil
+}
+
+// Rotate creates the next key when it is due, or at once when no active
+// key can sign here, drops keys nothing can still be signed by, and
+// reloads. Instances serialize on an advisory lock
+// so only one creates each key.
+func (s *KeySet) Rotate(ctx context.Context) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('signing_keys'))`); err != nil {
+		return err
+	}
+
+	var latest struct {
+		ActivatesAt time.Time `db:"activates_at"`
+		Algorithm   string    `db:"algorithm"`
+	}
+	err = tx.GetContext(ctx, &latest, `
+		SELECT activates_at, algorithm FROM signing_keys ORDER BY activates_at DESC LIMIT 1`)
+	now := time.Now()
+	var activatesAt time.Time
+	switch {
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return err
+	case err != nil, latest.Algorithm != s.algorithm:
+		// No key yet, or switching algorithm: sign with a new one at once
+		activatesAt = now
+	case !now.Before(latest.ActivatesAt.Add(s.rotateEvery - publishAhead)):
+		activatesAt = latest.ActivatesAt.Add(s.rotateEvery)
+		if activatesAt.Before(now) {
+			activatesAt = now
+		}
+	}
+
+	// Sign with a new key at once if no active key can sign here, e.g.
+	// after the secret changed and old private keys no longer decrypt
+	if activatesAt.IsZero() {
+		usable, err := s.canSign(ctx, tx, now)
+		if err != nil {
+			return err
+		}
+		if !usable {
+			activatesAt = now
+		}
+	}
+
+	if !activatesAt.IsZero() {
+		key, pubDER, sealed, err := s.generate()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO signing_keys (kid, algorithm, private_key, public_key, activates_at)
+			VALUES ($1, $2, $3, $4, $5)`, key.ID, key.Algorithm, sealed, pubDER, activatesAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A key is done once a newer key has been signing for longer than any
+	// token lives
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM signing_keys k
+		WHERE EXISTS (
+			SELECT 1 FROM signing_keys n
+			WHERE n.activates_at > k.activates_at AND n.activates_at < $1
+		)`, now.Add(-s.retainFor))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.Load(ctx)
+}
+
+// canSign reports whether a key of the configured algorithm active at now
+// has a private key this instance can decrypt.
+func (s *KeySet) canSign(ctx context.Context, tx *sqlx.Tx, now time.Time) (bool, error) {
+	var rows []keyRow
+	err := tx.SelectContext(ctx, &rows, `
+		SELECT kid, algorithm, private_key, public_key, activates_at
+		FROM signing_keys
+		WHERE algorithm = $1 AND activates_at <= $2`, s.algorithm, now)
+	if err != nil {
+		return false, err
+	}
+	for _, row := range rows {
+		if key, err := s.decode(row); err == nil && key.Private != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Run rotates and reloads the key set every interval until ctx is done.
+func (s *KeySet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Rotate(ctx); err != nil {
+			log.Printf("signing key rotation: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns every key that may sign or have signed a live token,
+// including one about to be activated.
+func (s *KeySet) JWKS() []JWK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]JWK, 0, len(s.keys))
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(bigEndian(pub.E))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
+
+func bigEndian(e int) []byte {
+	var b []byte
+	for ; e > 0; e >>= 8 {
+		b = append([]byte{byte(e)}, b...)
+	}
+	return b
+}
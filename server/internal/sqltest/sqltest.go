@@ -0,0 +1,169 @@
+// Package sqltest is a database/sql driver whose answers are scripted by
+// the test, for handlers whose behaviour depends on a few queries. Every
+// statement is recorded so tests can check what was run.
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Result is the answer to one statement. Columns and Rows answer queries;
+// RowsAffected answers Exec.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// Row is a single-row Result.
+func Row(columns []string, values ...driver.Value) Result {
+	return Result{Columns: columns, Rows: [][]driver.Value{values}, RowsAffected: 1}
+}
+
+// Statement is a statement the handler ran.
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// DB answers statements containing a registered fragment; anything else
+// gets no rows and affects nothing.
+type DB struct {
+	mu       sync.Mutex
+	handlers []handler
+	log      []Statement
+}
+
+type handler struct {
+	fragment string
+	answer   func(args []driver.Value) Result
+}
+
+// Open returns a sqlx handle backed by a new scripted DB.
+func Open(t testing.TB) (*sqlx.DB, *DB) {
+	t.Helper()
+	fake := &DB{}
+	db := sqlx.NewDb(sql.OpenDB(connector{fake}), "postgres")
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+// On answers statements containing fragment with result. Later
+// registrations take precedence.
+func (d *DB) On(fragment string, result Result) {
+	d.OnFunc(fragment, func([]driver.Value) Result { return result })
+}
+
+// OnFunc answers statements containing fragment by calling answer.
+func (d *DB) OnFunc(fragment string, answer func(args []driver.Value) Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers = append([]handler{{fragment, answer}}, d.handlers...)
+}
+
+// Ran returns the statements run so far that contain fragment.
+func (d *DB) Ran(fragment string) []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []Statement
+	for _, st := range d.log {
+		if strings.Contains(st.Query, fragment) {
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+func (d *DB) answer(query string, args []driver.NamedValue) Result {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	d.mu.Lock()
+	d.log = append(d.log, Statement{Query: query, Args: values})
+	var answer func([]driver.Value) Result
+	for _, h := range d.handlers {
+		if strings.Contains(query, h.fragment) {
+			answer = h.answer
+			break
+		}
+	}
+	d.mu.Unlock()
+	if answer == nil {
+		return Result{}
+	}
+	return answer(values)
+}
+
+type connector struct{ db *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return conn{c.db}, nil }
+func (c connector) Driver() driver.Driver                        { return drv{} }
+
+type drv struct{}
+
+func (drv) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("sqltest: open through sqltest.Open")
+}
+
+type conn struct{ db *DB }
+
+func (c conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("sqltest: prepared statements are not supported")
+}
+func (c conn) Close() error              { return nil }
+func (c conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+func (c conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return tx{}, nil }
+
+// CheckNamedValue accepts any argument type, as lib/pq's own encoding
+// isn't needed to answer a scripted statement.
+func (c conn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.answer(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{columns: res.Columns, values: res.Rows}, nil
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.db.answer(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
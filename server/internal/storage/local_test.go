@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalKeysStayInRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"../escape", "a/../../escape", "/../../escape", "./../escape"} {
+		if err := l.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Errorf("%q: %v", key, err)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "escape")); err == nil {
+			t.Fatalf("%q: wrote outside the storage root", key)
+		}
+		if _, err := os.Stat(filepath.Join(root, "escape")); err != nil {
+			t.Errorf("%q: not stored below the root: %v", key, err)
+		}
+	}
+
+	for _, key := range []string{"", "/", "..", "../", "a/.."} {
+		if err := l.Put(ctx, key, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("%q: accepted a key naming the root", key)
+		}
+		if _, err := l.Get(ctx, key); err == nil {
+			t.Errorf("%q: Get accepted a key naming the root", key)
+		}
+		if err := l.Delete(ctx, key); err == nil {
+			t.Errorf("%q: Delete accepted a key naming the root", key)
+		}
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, l)
+}
+
+// testRoundTrip runs the same Put/Get/Stat/List/Delete sequence against
+// any driver.
+func testRoundTrip(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	content := "0123456789abcdefghij"
+
+	if err := s.Put(ctx, "blobs/ab/abc", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "other/key", strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := s.Get(ctx, "blobs/ab/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != content {
+		t.Errorf("Get = %q, want %q", got, content)
+	}
+
+	ranges := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 1, "0"},
+		{5, 5, "56789"},
+		{15, 5, "fghij"},
+		{0, 20, content},
+	}
+	for _, r := range ranges {
+		rc, err := s.GetRange(ctx, "blobs/ab/abc", r.offset, r.length)
+		if err != nil {
+			t.Errorf("GetRange(%d, %d): %v", r.offset, r.length, err)
+			continue
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != r.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", r.offset, r.length, got, r.want)
+		}
+	}
+
+	info, err := s.Stat(ctx, "blobs/ab/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Stat size = %d, want %d", info.Size, len(content))
+	}
+
+	objects, err := s.List(ctx, "blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "blobs/ab/abc" {
+		t.Errorf("List(blobs/) = %+v", objects)
+	}
+
+	if err := s.Delete(ctx, "blobs/ab/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "blobs/ab/abc"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if _, err := s.Get(ctx, "blobs/ab/abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetRange(ctx, "blobs/ab/abc", 0, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRange after Delete: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Stat(ctx, "blobs/ab/abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete: err = %v, want ErrNotFound", err)
+	}
+}
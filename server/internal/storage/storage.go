@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Storage types recorded in files.storage_type.
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is a backend that holds file contents under slash-separated keys.
+// Readers returned by Get and GetRange are streamed and must be closed by
+// the caller. GetRange reads length bytes starting at offset; callers
+// validate the range against the object size.
+type Storage interface {
+	Type() string
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// Registry holds the backend new uploads are written to along with every
+// other configured backend, so files recorded under a different
+// storage_type stay readable after a deployment switches drivers.
+type Registry struct {
+	primary  Storage
+	backends map[string]Storage
+}
+
+func NewRegistry(primary Storage, others ...Storage) *Registry {
+	r := &Registry{
+		primary:  primary,
+		backends: map[string]Storage{primary.Type(): primary},
+	}
+	for _, s := range others {
+		if _, ok := r.backends[s.Type()]; !ok {
+			r.backends[s.Type()] = s
+		}
+	}
+	return r
+}
+
+// Primary returns the backend used for new uploads.
+func (r *Registry) Primary() Storage {
+	return r.primary
+}
+
+// Backend returns the backend for a files.storage_type value.
+func (r *Registry) Backend(storageType string) (Storage, error) {
+	s, ok := r.backends[storageType]
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not configured", storageType)
+	}
+	return s, nil
+}
+
+// NewRegistryFromEnv builds the registry from STORAGE_TYPE (local or s3),
+// STORAGE_PATH and the S3_* variables. The local driver is always
+// registered when STORAGE_PATH is set.
+func NewRegistryFromEnv() (*Registry, error) {
+	var local, s3 Storage
+
+	if path := os.Getenv("STORAGE_PATH"); path != "" {
+		l, err := NewLocal(path)
+		if err != nil {
+			return nil, err
+		}
+		local = l
+	}
+
+	if os.Getenv("S3_BUCKET") != "" {
+		s, err := NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+		})
+		if err != nil {
+			return nil, err
+		}
+		s3 = s
+	}
+
+	storageType := os.Getenv("STORAGE_TYPE")
+	if storageType == "" {
+		storageType = TypeLocal
+	}
+
+	switch storageType {
+	case TypeLocal:
+		if local == nil {
+			return nil, errors.New("STORAGE_PATH must be set for local storage")
+		}
+		if s3 != nil {
+			return NewRegistry(local, s3), nil
+		}
+		return NewRegistry(local), nil
+	case TypeS3:
+		if s3 == nil {
+			return nil, errors.New("S3_BUCKET must be set for s3 storage")
+		}
+		if local != nil {
+			return NewRegistry(s3, local), nil
+		}
+		return NewRegistry(s3), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_TYPE %q", storageType)
+	}
+}
@@ -0,0 +1,133 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Size is a named bounding box thumbnails are scaled to fit.
+type Size struct {
+	Name string
+	Max  int
+}
+
+// Sizes are generated for every supported image.
+var Sizes = []Size{
+	{Name: "small", Max: 128},
+	{Name: "medium", Max: 512},
+	{Name: "large", Max: 1024},
+}
+
+// DefaultSize is served when a request doesn't name one.
+const DefaultSize = "medium"
+
+// MaxPixels rejects images whose decoded form would be unreasonably large.
+const MaxPixels = 50_000_000
+
+var ErrTooLarge = errors.New("thumbnail: image dimensions too large")
+
+// LookupSize returns the size with the given name.
+func LookupSize(name string) (Size, bool) {
+	for _, s := range Sizes {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Size{}, false
+}
+
+// Supported reports whether thumbnails can be generated for a MIME type.
+func Supported(mimeType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0])) {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Image is one encoded thumbnail.
+type Image struct {
+	Size        string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Generate decodes r once (the first frame for GIFs) and renders every
+// size in Sizes. Images are never upscaled. Opaque images are encoded as
+// JPEG and images with transparency as PNG.
+func Generate(r io.Reader) ([]Image, error) {
+	var buf bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return nil, fmt.Errorf("thumbnail: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, fmt.Errorf("thumbnail: %w", err)
+	}
+	opaque := isOpaque(src)
+
+	images := make([]Image, 0, len(Sizes))
+	for _, size := range Sizes {
+		w, h := fit(src.Bounds().Dx(), src.Bounds().Dy(), size.Max)
+		dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+
+		img := Image{Size: size.Name, Width: w, Height: h}
+		var out bytes.Buffer
+		if opaque {
+			img.ContentType = "image/jpeg"
+			err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+		} else {
+			img.ContentType = "image/png"
+			err = png.Encode(&out, dst)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("thumbnail: %w", err)
+		}
+		img.Data = out.Bytes()
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// fit scales w×h to fit within bound×bound, keeping the aspect ratio.
+func fit(w, h, bound int) (int, int) {
+	if w <= bound && h <= bound {
+		return w, h
+	}
+	if w >= h {
+		return bound, maxInt(1, h*bound/w)
+	}
+	return maxInt(1, w*bound/h), bound
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+-- Resumable (tus) uploads in progress
+CREATE TABLE tus_uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    upload_length BIGINT NOT NULL CHECK (upload_length > 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    original_name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    metadata TEXT,
+    file_id UUID REFERENCES files(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_tus_uploads_user_id ON tus_uploads(user_id);
+CREATE INDEX idx_tus_uploads_expires_at ON tus_uploads(expires_at) WHERE completed_at IS NULL;
@@ -0,0 +1,17 @@
+-- Track which file_versions row is current and where each version lives
+ALTER TABLE files ADD COLUMN current_version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE file_versions ADD COLUMN storage_type VARCHAR(20) NOT NULL DEFAULT 'local'
+    CHECK (storage_type IN ('s3', 'local'));
+ALTER TABLE file_versions ADD COLUMN mime_type VARCHAR(100);
+
+-- Per-user override of how many versions to keep (NULL = deployment default)
+ALTER TABLE users ADD COLUMN version_retention INTEGER CHECK (version_retention > 0);
+
+-- Existing files become version 1
+INSERT INTO file_versions (file_id, version, storage_path, storage_type, mime_type, size, created_by)
+SELECT id, 1, storage_path, storage_type, mime_type, size, user_id
+FROM files
+ON CONFLICT (file_id, version) DO NOTHING;
+
+CREATE INDEX idx_file_versions_storage_path ON file_versions(storage_path);
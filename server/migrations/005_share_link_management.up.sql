@@ -0,0 +1,10 @@
+-- Share link management: optional expiry, passwords, download limits, revocation
+ALTER TABLE file_shares ALTER COLUMN expires_at DROP NOT NULL;
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE file_shares ADD COLUMN created_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE file_shares ADD COLUMN password_hash VARCHAR(255);
+ALTER TABLE file_shares ADD COLUMN max_downloads INTEGER CHECK (max_downloads > 0);
+ALTER TABLE file_shares ADD COLUMN download_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE file_shares ADD COLUMN revoked_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_file_shares_file_id ON file_shares(file_id);
@@ -0,0 +1,4 @@
+-- Soft delete: trashed files keep their row until purged
+ALTER TABLE files ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX idx_files_deleted_at ON files(deleted_at) WHERE deleted_at IS NOT NULL;
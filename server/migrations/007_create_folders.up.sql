@@ -0,0 +1,34 @@
+-- Logical folder tree; moving files never touches their stored objects
+CREATE TABLE folders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL CHECK (name <> '' AND name NOT IN ('.', '..') AND position('/' in name) = 0),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sibling folder names are unique (root folders have no parent)
+CREATE UNIQUE INDEX idx_folders_sibling_name
+    ON folders(user_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::uuid), name);
+CREATE INDEX idx_folders_parent_id ON folders(parent_id);
+
+ALTER TABLE files ADD COLUMN folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
+CREATE INDEX idx_files_folder_id ON files(folder_id);
+
+-- Folder permissions apply to everything below the folder
+CREATE TABLE folder_permissions (
+    folder_id UUID NOT NULL REFERENCES folders(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    can_view BOOLEAN DEFAULT TRUE,
+    can_edit BOOLEAN DEFAULT FALSE,
+    can_share BOOLEAN DEFAULT FALSE,
+    granted_by UUID REFERENCES users(id),
+    granted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (folder_id, user_id)
+);
+
+CREATE INDEX idx_folder_permissions_user_id ON folder_permissions(user_id);
+
+-- Resumable uploads can target a folder
+ALTER TABLE tus_uploads ADD COLUMN folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+-- GET /files sorts and filters on created_at, which the handlers have always
+-- used; make sure it exists on databases built from 002 alone
+ALTER TABLE files ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
+
+-- Keyset pagination for GET /files on each supported sort
+CREATE INDEX idx_files_user_created ON files(user_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_user_name ON files(user_id, original_name, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_user_size ON files(user_id, size, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,26 @@
+-- Full-text search over file names, user tags and extracted text content
+ALTER TABLE files ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE files ADD COLUMN content_text TEXT;
+ALTER TABLE files ADD COLUMN search_vector TSVECTOR;
+
+-- Names and tags use the 'simple' config so identifiers aren't stemmed;
+-- separators are split so "q3-report_final.pdf" matches "report"
+CREATE OR REPLACE FUNCTION files_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', coalesce(NEW.original_name, '')), 'A') ||
+        setweight(to_tsvector('simple', regexp_replace(coalesce(NEW.original_name, ''), '[._-]+', ' ', 'g')), 'A') ||
+        setweight(to_tsvector('simple', array_to_string(NEW.tags, ' ')), 'B') ||
+        setweight(to_tsvector('english', coalesce(NEW.content_text, '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER files_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF original_name, tags, content_text ON files
+    FOR EACH ROW EXECUTE FUNCTION files_search_vector_update();
+
+-- Backfill existing rows through the trigger
+UPDATE files SET original_name = original_name;
+
+CREATE INDEX idx_files_search ON files USING GIN(search_vector);
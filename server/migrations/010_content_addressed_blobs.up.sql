@@ -0,0 +1,19 @@
+-- Content-addressed storage: each distinct SHA-256 is stored once per
+-- backend and shared by every version row that holds that content.
+-- ref_count is the number of file_versions rows pointing at the blob.
+CREATE TABLE blobs (
+    storage_type VARCHAR(20) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    storage_path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (storage_type, checksum)
+);
+
+CREATE UNIQUE INDEX idx_blobs_path ON blobs(storage_type, storage_path);
+
+-- Hex SHA-256 of the content; NULL for objects stored before this migration,
+-- which keep their per-upload keys and are released by path
+ALTER TABLE files ADD COLUMN checksum CHAR(64);
+ALTER TABLE file_versions ADD COLUMN checksum CHAR(64);
@@ -0,0 +1,19 @@
+-- Thumbnails belong to a blob, so identical uploads share them and a new
+-- version (new content) gets its own set. NULL status means not attempted.
+ALTER TABLE blobs ADD COLUMN thumbnail_status VARCHAR(10)
+    CHECK (thumbnail_status IN ('pending', 'ready', 'failed'));
+
+CREATE TABLE thumbnails (
+    storage_type VARCHAR(20) NOT NULL,
+    blob_checksum CHAR(64) NOT NULL,
+    size VARCHAR(10) NOT NULL,
+    storage_path TEXT NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (storage_type, blob_checksum, size),
+    FOREIGN KEY (storage_type, blob_checksum) REFERENCES blobs(storage_type, checksum) ON DELETE CASCADE
+);
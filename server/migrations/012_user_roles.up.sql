@@ -0,0 +1,2 @@
+-- Roles select per-role upload content policies
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
@@ -0,0 +1,12 @@
+-- Malware scan state. Content is only downloadable or shareable once
+-- clean. Existing files start pending so the background sweep scans them.
+ALTER TABLE files ADD COLUMN scan_status VARCHAR(10) NOT NULL DEFAULT 'pending'
+    CHECK (scan_status IN ('pending', 'clean', 'infected', 'error'));
+ALTER TABLE files ADD COLUMN scan_signature TEXT;
+ALTER TABLE files ADD COLUMN scanned_at TIMESTAMPTZ;
+
+-- Admin review of quarantined files
+ALTER TABLE files ADD COLUMN scan_reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE files ADD COLUMN scan_reviewed_at TIMESTAMPTZ;
+
+CREATE INDEX idx_files_scan_status ON files(scan_status) WHERE scan_status <> 'clean';
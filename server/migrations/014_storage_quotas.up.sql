@@ -0,0 +1,18 @@
+-- Storage plans; NULL limits are unlimited
+CREATE TABLE plans (
+    name VARCHAR(50) PRIMARY KEY,
+    max_bytes BIGINT CHECK (max_bytes >= 0),
+    max_files INTEGER CHECK (max_files >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO plans (name, max_bytes, max_files) VALUES
+    ('free', 5368709120, 10000),
+    ('pro', 1099511627776, 1000000),
+    ('unlimited', NULL, NULL);
+
+-- Per-user overrides take precedence over the plan's limits
+ALTER TABLE users ADD COLUMN plan VARCHAR(50) NOT NULL DEFAULT 'free' REFERENCES plans(name);
+ALTER TABLE users ADD COLUMN quota_bytes BIGINT CHECK (quota_bytes >= 0);
+ALTER TABLE users ADD COLUMN quota_files INTEGER CHECK (quota_files >= 0);
+
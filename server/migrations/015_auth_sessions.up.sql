@@ -0,0 +1,17 @@
+-- Each auth_tokens row is a login session, identified publicly by the
+-- token's jti claim
+ALTER TABLE auth_tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE auth_tokens ADD COLUMN jti VARCHAR(64);
+ALTER TABLE auth_tokens ADD COLUMN user_agent TEXT;
+ALTER TABLE auth_tokens ADD COLUMN ip_address INET;
+ALTER TABLE auth_tokens ADD COLUMN revoked_at TIMESTAMPTZ;
+
+-- Backfill jti for live tokens from the JWT payload
+UPDATE auth_tokens
+SET jti = convert_from(decode(rpad(translate(split_part(token, '.', 2), '-_', '+/'),
+                                   ((length(split_part(token, '.', 2)) + 3) / 4) * 4, '='),
+                              'base64'), 'UTF8')::json->>'jti'
+WHERE expires_at > NOW();
+
+CREATE UNIQUE INDEX idx_auth_tokens_jti ON auth_tokens(jti);
+CREATE INDEX idx_auth_tokens_user_active ON auth_tokens(user_id, expires_at) WHERE revoked_at IS NULL;
@@ -0,0 +1,46 @@
+-- A session is one login: a refresh token family and the access tokens
+-- issued from it
+CREATE TABLE auth_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT,
+    ip_address INET,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_refreshed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_auth_sessions_user_active ON auth_sessions(user_id, expires_at) WHERE revoked_at IS NULL;
+
+ALTER TABLE auth_tokens ADD COLUMN session_id UUID REFERENCES auth_sessions(id) ON DELETE CASCADE;
+CREATE INDEX idx_auth_tokens_session ON auth_tokens(session_id);
+
+-- Live tokens issued before refresh tokens each become their own session
+WITH live AS (
+    SELECT jti, gen_random_uuid() AS session_id, user_id, user_agent, ip_address,
+           created_at, expires_at, revoked_at
+    FROM auth_tokens
+    WHERE jti IS NOT NULL AND expires_at > NOW()
+), sessions AS (
+    INSERT INTO auth_sessions (id, user_id, user_agent, ip_address, created_at, expires_at, revoked_at)
+    SELECT session_id, user_id, user_agent, ip_address, created_at, expires_at, revoked_at FROM live
+)
+UPDATE auth_tokens t SET session_id = live.session_id
+FROM live
+WHERE t.jti = live.jti;
+
+-- Refresh tokens are opaque and stored as SHA-256 hashes. Each is single
+-- use: refreshing marks it used and issues its replacement in the same
+-- session.
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id UUID NOT NULL REFERENCES auth_sessions(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    replaced_by UUID REFERENCES refresh_tokens(id)
+);
+
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens(session_id);
@@ -0,0 +1,12 @@
+-- Asymmetric keys for access tokens. private_key is PKCS#8 sealed with
+-- AES-GCM under a key derived from JWT_SECRET; public_key is PKIX DER.
+CREATE TABLE signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(10) NOT NULL CHECK (algorithm IN ('RS256', 'EdDSA')),
+    private_key BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    activates_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_signing_keys_activates_at ON signing_keys(activates_at);
@@ -0,0 +1,7 @@
+-- Roles are user, moderator and admin; disabled accounts can't sign in
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN disabled_reason TEXT;
+ALTER TABLE users ADD COLUMN disabled_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_users_email_lower ON users(lower(email));
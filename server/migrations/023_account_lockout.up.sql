@@ -0,0 +1,4 @@
+-- Locked-out accounts are emailed a single-use unlock link
+ALTER TABLE user_tokens DROP CONSTRAINT user_tokens_purpose_check;
+ALTER TABLE user_tokens ADD CONSTRAINT user_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password', 'unlock_account'));
@@ -0,0 +1,12 @@
+-- Each version keeps its own scan verdict, so older versions can't be
+-- downloaded on the strength of the current one's. Versions sharing the
+-- current object take its verdict; the rest are left for the sweep.
+ALTER TABLE file_versions ADD COLUMN scan_status VARCHAR(10) NOT NULL DEFAULT 'pending'
+    CHECK (scan_status IN ('pending', 'clean', 'infected', 'error'));
+
+UPDATE file_versions v
+SET scan_status = f.scan_status
+FROM files f
+WHERE f.id = v.file_id AND f.storage_path = v.storage_path;
+
+CREATE INDEX idx_file_versions_scan_pending ON file_versions(created_at) WHERE scan_status = 'pending';
@@ -0,0 +1,7 @@
+-- Scans that fail for a transient reason (scanner or storage unreachable,
+-- timeout) stay pending and are retried with a growing delay; only the
+-- scanner's own verdicts mark content as unscannable.
+ALTER TABLE files ADD COLUMN scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN scan_retry_at TIMESTAMPTZ;
+ALTER TABLE file_versions ADD COLUMN scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE file_versions ADD COLUMN scan_retry_at TIMESTAMPTZ;
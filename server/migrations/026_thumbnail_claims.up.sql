@@ -0,0 +1,3 @@
+-- When a thumbnail job claimed the blob, so the sweep can re-queue jobs
+-- that were lost (e.g. to a restart) instead of leaving them pending.
+ALTER TABLE blobs ADD COLUMN thumbnail_claimed_at TIMESTAMPTZ;
@@ -15,6 +15,8 @@ type File struct {
     StorageType  string    `db:"storage_type"` // "s3" or "local"
     URL          string    `db:"url"`          // Changed from PublicURL to URL
     IsPublic     bool      `db:"is_public"`
+    CurrentVersion int     `db:"current_version"`
+    FolderID     *string   `db:"folder_id"`
     UploadedAt   time.Time `db:"uploaded_at"`
     UpdatedAt    time.Time `db:"updated_at"`
 }
@@ -35,7 +37,17 @@ type FileVersion struct {
 	FileID       string    `db:"file_id"`
 	Version      int       `db:"version"`
 	StoragePath  string    `db:"storage_path"`
+	StorageType  string    `db:"storage_type"`
+	MimeType     string    `db:"mime_type"`
 	Size         int64     `db:"size"`
 	CreatedBy    string    `db:"created_by"`
 	CreatedAt    time.Time `db:"created_at"`
-}
\ No newline at end of file
+}
+type Folder struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	ParentID  *string   `db:"parent_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
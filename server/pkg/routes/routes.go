@@ -1,8 +1,13 @@
 package routes
 
 import (
+	"context"
+	"time"
+
 	"github.com/YogendrasinghRathod/server/internal/auth"
 	"github.com/YogendrasinghRathod/server/internal/file"
+	"github.com/YogendrasinghRathod/server/internal/ratelimit"
+	"github.com/YogendrasinghRathod/server/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9" // Updated to v9
 	"github.com/jmoiron/sqlx"
@@ -13,29 +18,172 @@ func SetupRoutes(
 	db *sqlx.DB,
 	redisClient *redis.Client, // Now using v9 client type
 	authHandler *auth.AuthHandler,
+	storageRegistry *storage.Registry,
 ) {
 	// Initialize file handler
 	fileHandler := file.NewFileHandler(
-		"./uploads",
+		storageRegistry,
 		db,
 		redisClient,
 	)
 
+	// Background cleanup of abandoned resumable uploads and expired trash,
+	// reruns of interrupted scans and thumbnail jobs, and signing key
+	// rotation
+	go fileHandler.RunUploadExpiry(context.Background(), time.Hour)
+	go fileHandler.RunTrashPurge(context.Background(), time.Hour)
+	go fileHandler.RunScanSweep(context.Background(), 5*time.Minute)
+	go fileHandler.RunThumbnailSweep(context.Background(), 5*time.Minute)
+	go authHandler.RunKeyRotation(context.Background(), time.Minute)
+
+	// Rate limits are shared across instances through Redis. Public routes
+	// count per IP; authenticated ones per user or API key.
+	limiter, err := ratelimit.FromEnv(redisClient)
+	if err != nil {
+		panic("failed to configure rate limits: " + err.Error())
+	}
+	loginLimit := limiter.Limit("login", ratelimit.ByIP)
+	accountLimit := limiter.Limit("account", ratelimit.ByIP)
+	shareLimit := limiter.Limit("share", ratelimit.ByIP)
+	uploadLimit := limiter.Limit("upload", ratelimit.ByClient)
+	apiLimit := limiter.Limit("api", ratelimit.ByClient)
+
 	// Public routes
 	public := router.Group("/")
 	{
-		public.POST("/login", authHandler.Login)
-		public.POST("/register", authHandler.Register)
-		public.GET("/share/:token", fileHandler.ServeSharedFile)
+		public.GET("/.well-known/jwks.json", authHandler.JWKS)
+		public.POST("/login", loginLimit, authHandler.Login)
+		public.POST("/login/2fa", loginLimit, authHandler.LoginTwoFactor)
+		public.POST("/register", accountLimit, authHandler.Register)
+		public.GET("/oidc/login", loginLimit, authHandler.OIDCLogin)
+		public.GET("/oidc/callback", loginLimit, authHandler.OIDCCallback)
+		public.POST("/token/refresh", loginLimit, authHandler.Refresh)
+		public.POST("/verify-email", accountLimit, authHandler.VerifyEmail)
+		public.POST("/verify-email/resend", accountLimit, authHandler.ResendVerification)
+		public.POST("/password/forgot", accountLimit, authHandler.ForgotPassword)
+		public.POST("/password/reset", accountLimit, authHandler.ResetPassword)
+		public.POST("/unlock-account", accountLimit, authHandler.UnlockAccount)
+		public.GET("/share/:token", shareLimit, limiter.Bandwidth("share_bandwidth", ratelimit.ByIP), fileHandler.ServeSharedFile)
+		public.GET("/share/:token/thumbnail", shareLimit, fileHandler.GetSharedThumbnail)
+		public.OPTIONS("/uploads", fileHandler.TusOptions)
 	}
 
-	// Protected routes
+	// Protected routes; these need a login session, API keys are refused
 	protected := router.Group("/")
-	protected.Use(authHandler.AuthMiddleware())
+	protected.Use(authHandler.AuthMiddleware(), apiLimit)
 	{
-		protected.POST("/upload", fileHandler.Upload)
-		protected.GET("/files", fileHandler.GetUserFiles)
-		protected.GET("/files/:file_id/download", fileHandler.Download)
-		protected.POST("/files/:file_id/share", fileHandler.CreateShareLink)
+		// Sessions
+		protected.POST("/logout", authHandler.Logout)
+		protected.GET("/sessions", authHandler.ListSessions)
+		protected.DELETE("/sessions", authHandler.RevokeOtherSessions)
+		protected.DELETE("/sessions/:session_id", authHandler.RevokeSession)
+
+		// Two-factor authentication
+		protected.POST("/2fa/setup", authHandler.SetupTwoFactor)
+		protected.POST("/2fa/enable", authHandler.EnableTwoFactor)
+		protected.POST("/2fa/disable", authHandler.DisableTwoFactor)
+		protected.POST("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+
+		// Linked OIDC identities
+		protected.POST("/oidc/link", authHandler.OIDCLink)
+		protected.GET("/me/identities", authHandler.ListIdentities)
+		protected.DELETE("/me/identities/:identity_id", authHandler.UnlinkIdentity)
+
+		// API keys
+		protected.POST("/api-keys", authHandler.CreateAPIKey)
+		protected.GET("/api-keys", authHandler.ListAPIKeys)
+		protected.DELETE("/api-keys/:key_id", authHandler.RevokeAPIKey)
+
+		protected.DELETE("/files/:file_id", fileHandler.DeleteFile)
+
+		// Folders
+		protected.PATCH("/files/:file_id", fileHandler.UpdateFile)
+		protected.PUT("/files/:file_id/tags", fileHandler.SetTags)
+		protected.POST("/folders", fileHandler.CreateFolder)
+		protected.PATCH("/folders/:folder_id", fileHandler.UpdateFolder)
+		protected.DELETE("/folders/:folder_id", fileHandler.DeleteFolder)
+
+		// Trash
+		protected.GET("/trash", fileHandler.ListTrash)
+		protected.POST("/trash/:file_id/restore", fileHandler.RestoreFile)
+		protected.DELETE("/trash/:file_id", fileHandler.PurgeTrashedFile)
+		protected.DELETE("/trash", fileHandler.EmptyTrash)
+
+		// File versions
+		protected.POST("/files/:file_id/versions/:version/restore", fileHandler.RestoreVersion)
+		protected.DELETE("/files/:file_id/versions/:version", fileHandler.DeleteVersion)
+		protected.PUT("/me/version-retention", fileHandler.SetVersionRetention)
+	}
+
+	// Read access; also open to API keys with the read scope
+	readable := router.Group("/")
+	readable.Use(authHandler.AuthMiddleware(auth.ScopeRead), apiLimit)
+	{
+		readable.GET("/files", fileHandler.GetUserFiles)
+		readable.GET("/files/:file_id/download", fileHandler.Download)
+		readable.GET("/files/shared", fileHandler.GetSharedWithMe)
+		readable.GET("/files/by-path", fileHandler.GetFileByPath)
+		readable.GET("/files/:file_id/thumbnail", fileHandler.GetThumbnail)
+		readable.GET("/search", fileHandler.Search)
+		readable.GET("/folders/shared", fileHandler.GetSharedFolders)
+		readable.GET("/folders/:folder_id/children", fileHandler.ListFolderChildren)
+		readable.GET("/files/:file_id/versions", fileHandler.ListVersions)
+		readable.GET("/files/:file_id/versions/:version/download", fileHandler.DownloadVersion)
+		readable.GET("/me/usage", fileHandler.GetUsage)
+	}
+
+	// Uploads; also open to API keys with the upload scope
+	uploads := router.Group("/")
+	uploads.Use(authHandler.AuthMiddleware(auth.ScopeUpload), apiLimit)
+	{
+		uploads.POST("/upload", uploadLimit, fileHandler.Upload)
+		uploads.POST("/files/:file_id/versions", uploadLimit, fileHandler.UploadVersion)
+
+		// Resumable uploads (tus 1.0)
+		uploads.POST("/uploads", uploadLimit, fileHandler.TusCreate)
+		uploads.HEAD("/uploads/:upload_id", fileHandler.TusHead)
+		uploads.PATCH("/uploads/:upload_id", fileHandler.TusPatch)
+		uploads.DELETE("/uploads/:upload_id", fileHandler.TusDelete)
+	}
+
+	// Share links and sharing with other users; also open to API keys with
+	// the share scope
+	sharing := router.Group("/")
+	sharing.Use(authHandler.AuthMiddleware(auth.ScopeShare), apiLimit)
+	{
+		sharing.POST("/files/:file_id/share", fileHandler.CreateShareLink)
+		sharing.GET("/files/:file_id/shares", fileHandler.ListShareLinks)
+		sharing.DELETE("/files/:file_id/shares/:token", fileHandler.RevokeShareLink)
+		sharing.GET("/files/:file_id/permissions", fileHandler.ListPermissions)
+		sharing.POST("/files/:file_id/permissions", fileHandler.GrantPermission)
+		sharing.PATCH("/files/:file_id/permissions/:user_id", fileHandler.UpdatePermission)
+		sharing.DELETE("/files/:file_id/permissions/:user_id", fileHandler.RevokePermission)
+		sharing.GET("/folders/:folder_id/permissions", fileHandler.ListFolderPermissions)
+		sharing.POST("/folders/:folder_id/permissions", fileHandler.GrantFolderPermission)
+		sharing.PATCH("/folders/:folder_id/permissions/:user_id", fileHandler.UpdateFolderPermission)
+		sharing.DELETE("/folders/:folder_id/permissions/:user_id", fileHandler.RevokeFolderPermission)
+	}
+
+	// Admin routes; API keys need the admin scope, and each route checks
+	// the caller's role for its permission
+	admin := router.Group("/admin")
+	admin.Use(authHandler.AuthMiddleware(auth.ScopeAdmin), apiLimit)
+	{
+		admin.GET("/users", authHandler.RequirePermission(auth.PermViewUsers), authHandler.ListUsers)
+		admin.GET("/users/:user_id", authHandler.RequirePermission(auth.PermViewUsers), authHandler.GetUser)
+		admin.PATCH("/users/:user_id", authHandler.RequirePermission(auth.PermManageUsers), authHandler.UpdateUserRole)
+		admin.POST("/users/:user_id/disable", authHandler.RequirePermission(auth.PermManageUsers), authHandler.DisableUser)
+		admin.POST("/users/:user_id/enable", authHandler.RequirePermission(auth.PermManageUsers), authHandler.EnableUser)
+		admin.POST("/users/:user_id/reset-password", authHandler.RequirePermission(auth.PermManageUsers), authHandler.AdminResetPassword)
+		admin.POST("/users/:user_id/unlock", authHandler.RequirePermission(auth.PermManageUsers), authHandler.AdminUnlockUser)
+		admin.PUT("/users/:user_id/quota", authHandler.RequirePermission(auth.PermManageUsers), fileHandler.SetUserQuota)
+		admin.GET("/files/:file_id", authHandler.RequirePermission(auth.PermViewAnyFile), fileHandler.GetAnyFile)
+		admin.DELETE("/shares/:token", authHandler.RequirePermission(auth.PermRevokeAnyShare), fileHandler.ForceRevokeShareLink)
+
+		quarantine := admin.Group("/quarantine", authHandler.RequirePermission(auth.PermManageQuarantine))
+		quarantine.GET("", fileHandler.ListQuarantine)
+		quarantine.POST("/:file_id/release", fileHandler.ReleaseQuarantinedFile)
+		quarantine.POST("/:file_id/rescan", fileHandler.RescanQuarantinedFile)
+		quarantine.DELETE("/:file_id", fileHandler.PurgeQuarantinedFile)
 	}
 }
\ No newline at end of file